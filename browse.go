@@ -0,0 +1,483 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+const browseHelp = "enter:print  ^E:edit  ^O:copy to file  ^D:delete  ^T:retag  esc:quit"
+
+// fuzzyScore matches pattern against text as a case insensitive
+// subsequence. Consecutive matches and matches at word starts score higher.
+func fuzzyScore(pattern string, text string) (int, bool) {
+    p := []rune(strings.ToLower(pattern))
+    t := []rune(strings.ToLower(text))
+    score := 0
+    pi := 0
+    prev := -2
+    for ti := 0; ti < len(t) && pi < len(p); ti++ {
+        if t[ti] != p[pi] {
+            continue
+        }
+        score++
+        if ti == prev+1 {
+            score += 3
+        }
+        if ti == 0 || (!unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1])) {
+            score += 2
+        }
+        prev = ti
+        pi++
+    }
+    return score, pi == len(p)
+}
+
+// fuzzyFilter returns the segments matching every word of query over id,
+// category, tags and desc, best matches first.
+func fuzzyFilter(segs []CodeSegment, query string) []CodeSegment {
+    words := strings.Fields(query)
+    if len(words) == 0 {
+        return segs
+    }
+
+    type scored struct {
+        cs    CodeSegment
+        score int
+    }
+    matched := []scored{}
+    for _, cs := range segs {
+        text := cs.Id + " " + cs.Category + " " + cs.Tags + " " + cs.Desc
+        total := 0
+        ok := true
+        for _, w := range words {
+            score, match := fuzzyScore(w, text)
+            if !match {
+                ok = false
+                break
+            }
+            total += score
+        }
+        if ok {
+            matched = append(matched, scored{cs, total})
+        }
+    }
+
+    sort.SliceStable(matched, func(i, j int) bool { return matched[i].score > matched[j].score })
+    res := []CodeSegment{}
+    for _, m := range matched {
+        res = append(res, m.cs)
+    }
+    return res
+}
+
+// browser is a full screen terminal UI to filter and pick code segments. It
+// draws on /dev/tty, so the picked result can be piped.
+type browser struct {
+    op        *Operator
+    tty       *os.File
+    sttyState string
+    printer   *Printer
+    all       []CodeSegment
+    matches   []CodeSegment
+    query     string
+    cursor    int
+    offset    int
+    status    string
+}
+
+// Browse opens the browser. The selected segment is printed on enter, only
+// its code if codeOnly is set.
+func (op *Operator) Browse(codeOnly bool) {
+    if op.err != nil {
+        return
+    }
+
+    tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+    if err != nil {
+        op.err = errors.New("can not open terminal: " + err.Error())
+        return
+    }
+    defer tty.Close()
+
+    printer := newPrinter()
+    _, noColor := os.LookupEnv("NO_COLOR")
+    printer.Color = !noColor
+
+    b := &browser{op: op, tty: tty, printer: printer}
+    b.reload()
+    if err = b.enterRaw(); err != nil {
+        op.err = err
+        return
+    }
+    cs, ok := b.loop()
+    b.leaveRaw()
+
+    if !ok {
+        return
+    }
+    op.recordUse(cs.Id)
+    if codeOnly {
+        fmt.Println(cs.Code)
+    } else {
+        cs.PrintToScreen()
+    }
+}
+
+func (b *browser) stty(args ...string) (string, error) {
+    cmd := exec.Command("stty", args...)
+    cmd.Stdin = b.tty
+    out, err := cmd.Output()
+    return strings.TrimSpace(string(out)), err
+}
+
+func (b *browser) enterRaw() error {
+    state, err := b.stty("-g")
+    if err != nil {
+        return errors.New("can not get terminal state: " + err.Error())
+    }
+    b.sttyState = state
+    if _, err = b.stty("raw", "-echo"); err != nil {
+        return errors.New("can not set terminal to raw mode: " + err.Error())
+    }
+    // switch to the alternate screen and hide the cursor.
+    b.tty.WriteString("\x1b[?1049h\x1b[?25l")
+    return nil
+}
+
+func (b *browser) leaveRaw() {
+    b.tty.WriteString("\x1b[?25h\x1b[?1049l")
+    b.stty(b.sttyState)
+}
+
+func (b *browser) reload() {
+    b.all = b.op.store.Search("", "")
+    b.filter()
+}
+
+func (b *browser) filter() {
+    b.matches = fuzzyFilter(b.all, b.query)
+    if b.cursor >= len(b.matches) {
+        b.cursor = len(b.matches) - 1
+    }
+    if b.cursor < 0 {
+        b.cursor = 0
+    }
+}
+
+func (b *browser) selected() (CodeSegment, bool) {
+    if len(b.matches) == 0 {
+        return CodeSegment{}, false
+    }
+    return b.matches[b.cursor], true
+}
+
+// loop handles keys until a segment is picked or the browser is quit.
+func (b *browser) loop() (CodeSegment, bool) {
+    buf := make([]byte, 64)
+    for {
+        b.render()
+        n, err := b.tty.Read(buf)
+        if err != nil {
+            return CodeSegment{}, false
+        }
+        b.status = ""
+
+        key := string(buf[:n])
+        if strings.HasPrefix(key, "\x1b") {
+            switch key {
+            case "\x1b[A", "\x1bOA":
+                b.move(-1)
+            case "\x1b[B", "\x1bOB":
+                b.move(1)
+            case "\x1b[5~":
+                b.move(-b.listHeight())
+            case "\x1b[6~":
+                b.move(b.listHeight())
+            case "\x1b":
+                return CodeSegment{}, false
+            }
+            continue
+        }
+
+        for _, r := range key {
+            switch r {
+            case '\x03':
+                return CodeSegment{}, false
+            case '\r', '\n':
+                if cs, ok := b.selected(); ok {
+                    return cs, true
+                }
+            case '\x10':
+                b.move(-1)
+            case '\x0e':
+                b.move(1)
+            case '\x7f', '\x08':
+                if q := []rune(b.query); len(q) > 0 {
+                    b.query = string(q[:len(q)-1])
+                    b.filter()
+                }
+            case '\x15':
+                b.query = ""
+                b.filter()
+            case '\x05':
+                b.edit()
+            case '\x0f':
+                b.copyToFile()
+            case '\x04':
+                b.delete()
+            case '\x14':
+                b.retag()
+            default:
+                if unicode.IsPrint(r) {
+                    b.query += string(r)
+                    b.cursor = 0
+                    b.offset = 0
+                    b.filter()
+                }
+            }
+        }
+    }
+}
+
+func (b *browser) move(delta int) {
+    b.cursor += delta
+    if b.cursor >= len(b.matches) {
+        b.cursor = len(b.matches) - 1
+    }
+    if b.cursor < 0 {
+        b.cursor = 0
+    }
+}
+
+// do runs an operator action and shows its error or msg in the status line.
+func (b *browser) do(msg string, action func()) {
+    action()
+    if b.op.err != nil {
+        b.status = "error: " + b.op.err.Error()
+        b.op.err = nil
+    } else {
+        b.status = msg
+    }
+    b.reload()
+}
+
+func (b *browser) edit() {
+    cs, ok := b.selected()
+    if !ok {
+        return
+    }
+    b.leaveRaw()
+    b.do("edited "+cs.Id, func() { b.op.Edit(cs.Id) })
+    if err := b.enterRaw(); err != nil {
+        b.status = "error: " + err.Error()
+    }
+}
+
+func (b *browser) copyToFile() {
+    cs, ok := b.selected()
+    if !ok {
+        return
+    }
+    fpath, ok := b.prompt("copy to file: ", "")
+    if !ok || fpath == "" {
+        return
+    }
+    b.do("copied to "+fpath, func() { b.op.err = writeCodeToFile(fpath, cs) })
+}
+
+func (b *browser) delete() {
+    cs, ok := b.selected()
+    if !ok {
+        return
+    }
+    // segments including it can no longer be expanded, like rcs remove warns.
+    label := "delete " + cs.Id + "? yes|no: "
+    if dependents := b.op.Dependents(cs.Id); len(dependents) > 0 {
+        ids := []string{}
+        for _, dep := range dependents {
+            ids = append(ids, dep.Id)
+        }
+        label = "included by " + strings.Join(ids, ", ") + ", " + label
+    }
+    answer, ok := b.prompt(label, "")
+    if !ok || strings.ToUpper(answer) != "YES" {
+        return
+    }
+    b.do("deleted "+cs.Id, func() { b.op.Remove(cs.Id) })
+}
+
+func (b *browser) retag() {
+    cs, ok := b.selected()
+    if !ok {
+        return
+    }
+    tagStr, ok := b.prompt("tags: ", cs.Tags)
+    if !ok {
+        return
+    }
+    b.do("retagged "+cs.Id, func() { b.op.Retag(cs.Id, tagStr) })
+}
+
+// prompt reads a line of input in the status line. It returns false if
+// the input is cancelled with esc.
+func (b *browser) prompt(label string, input string) (string, bool) {
+    buf := make([]byte, 64)
+    for {
+        b.status = label + input
+        b.render()
+        n, err := b.tty.Read(buf)
+        if err != nil {
+            return "", false
+        }
+
+        key := string(buf[:n])
+        if strings.HasPrefix(key, "\x1b") {
+            if key == "\x1b" {
+                b.status = ""
+                return "", false
+            }
+            continue
+        }
+
+        for _, r := range key {
+            switch r {
+            case '\r', '\n':
+                b.status = ""
+                return strings.TrimSpace(input), true
+            case '\x03':
+                b.status = ""
+                return "", false
+            case '\x7f', '\x08':
+                if in := []rune(input); len(in) > 0 {
+                    input = string(in[:len(in)-1])
+                }
+            default:
+                if unicode.IsPrint(r) {
+                    input += string(r)
+                }
+            }
+        }
+    }
+}
+
+func (b *browser) size() (rows int, cols int) {
+    rows, cols = ttySize(b.tty)
+    if rows <= 0 || cols <= 0 {
+        return 24, 80
+    }
+    return
+}
+
+func (b *browser) listHeight() int {
+    rows, _ := b.size()
+    return rows - 2
+}
+
+// truncate cuts s to at most width runes and expands tabs.
+func truncate(s string, width int) string {
+    r := []rune(strings.Replace(s, "\t", "    ", -1))
+    if width < 0 {
+        width = 0
+    }
+    if len(r) > width {
+        r = r[:width]
+    }
+    return string(r)
+}
+
+// render draws the query line, the list pane on the left, the preview pane
+// on the right and the status line at the bottom.
+func (b *browser) render() {
+    rows, cols := b.size()
+    listWidth := cols * 2 / 5
+    previewWidth := cols - listWidth - 3
+    height := rows - 2
+
+    if b.cursor < b.offset {
+        b.offset = b.cursor
+    }
+    if b.cursor >= b.offset+height {
+        b.offset = b.cursor - height + 1
+    }
+
+    var sb strings.Builder
+    var line = func(row int, content string) {
+        sb.WriteString("\x1b[" + strconv.Itoa(row) + ";1H" + content + "\x1b[K")
+    }
+
+    count := strconv.Itoa(len(b.matches)) + "/" + strconv.Itoa(len(b.all))
+    line(1, truncate("> "+b.query, cols-len(count)-1)+"\x1b["+strconv.Itoa(cols-len(count)+1)+"G"+count)
+
+    preview := b.previewLines(previewWidth)
+    for i := 0; i < height; i++ {
+        item := ""
+        if idx := b.offset + i; idx < len(b.matches) {
+            cs := b.matches[idx]
+            desc := strings.SplitN(cs.Desc, "\n", 2)[0]
+            item = fmt.Sprintf("%-*s", listWidth, truncate(cs.Category+" ["+cs.Tags+"] "+desc, listWidth))
+            if idx == b.cursor {
+                item = "\x1b[7m" + item + ansiReset
+            }
+        } else {
+            item = strings.Repeat(" ", listWidth)
+        }
+
+        p := ""
+        if i < len(preview) {
+            p = preview[i]
+        }
+        line(i+2, item+" | "+p)
+    }
+
+    status := b.status
+    if status == "" {
+        status = browseHelp
+    }
+    line(rows, truncate(status, cols))
+    b.tty.WriteString(sb.String())
+}
+
+// previewLines returns the selected segment, highlighted and cut to width.
+func (b *browser) previewLines(width int) []string {
+    cs, ok := b.selected()
+    if !ok {
+        return nil
+    }
+
+    lines := []string{
+        b.printer.label("  ID:") + " " + truncate(cs.Id, width-6),
+        b.printer.label("CATE:") + " " + truncate(cs.Category, width-6),
+        b.printer.label("TAGS:") + " " + truncate(cs.Tags, width-6),
+        b.printer.label("DESC:") + " " + truncate(strings.SplitN(cs.Desc, "\n", 2)[0], width-6),
+        "",
+    }
+
+    hl := newHighlighter(cs.EffectiveLanguage())
+    for _, code := range strings.Split(cs.Code, "\n") {
+        code = truncate(code, width)
+        if b.printer.Color {
+            code = b.printer.render(code, hl.tokenize(code))
+        }
+        lines = append(lines, code)
+    }
+    return lines
+}
+
+// writeCodeToFile writes the code of cs to a new file, it never overwrites
+// an existing file.
+func writeCodeToFile(fpath string, cs CodeSegment) error {
+    f, err := os.OpenFile(fpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    _, err = f.WriteString(cs.Code + "\n")
+    return err
+}
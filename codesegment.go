@@ -4,30 +4,75 @@ import (
     "bufio"
     "crypto/sha1"
     "encoding/base64"
+    "encoding/json"
     "errors"
     "fmt"
+    "io"
+    "io/ioutil"
     "os"
+    "path/filepath"
     "strings"
 )
 
 const IdLen = 27
 
+// CategorySep separates the levels of a hierarchical category, eg: go/net/http.
+const CategorySep = '/'
+
+// IsSubCategory reports whether cate is parent or one of its descendants.
+func IsSubCategory(cate string, parent string) bool {
+    return cate == parent || strings.HasPrefix(cate, parent+string(CategorySep))
+}
+
 type CodeSegment struct {
     Id, Category, Tags, Desc, Code string
+    Language                       string
+    // Files are the named files of a multi-file segment, empty for a plain
+    // segment. Code is always the content of the first file.
+    Files []CodeFile
+    // Test is a shell command run in the directory the segment is written
+    // to, Expect its expected output. Either makes the segment testable.
+    Test, Expect string
 }
 
-func (cs CodeSegment) PrintToScreen() {
-    fmt.Printf("  ID: %s\nCATE: %s\nTAGS: %s\n", cs.Id, cs.Category, cs.Tags)
-    fmt.Printf("DESC: %s\n", cs.Desc)
-    codeLines := strings.Split(cs.Code, "\n")
-    for i, line := range codeLines {
-        if i == 0 {
-            fmt.Println("CONTENT:")
-            fmt.Println("      " + line)
-        } else {
-            fmt.Println("      " + line)
+// CodeFile is one named file of a multi-file segment.
+type CodeFile struct {
+    Name    string
+    Content string
+}
+
+// AllFiles returns the files of a multi-file segment with the first one
+// taking its content from Code, or nil for a plain segment.
+func (cs CodeSegment) AllFiles() []CodeFile {
+    if len(cs.Files) == 0 {
+        return nil
+    }
+    files := append([]CodeFile{}, cs.Files...)
+    files[0].Content = cs.Code
+    return files
+}
+
+// Equal reports whether cs and other have the same fields and files.
+func (cs CodeSegment) Equal(other CodeSegment) bool {
+    if cs.Id != other.Id || cs.Category != other.Category || cs.Tags != other.Tags ||
+        cs.Desc != other.Desc || cs.Code != other.Code || cs.Language != other.Language ||
+        cs.Test != other.Test || cs.Expect != other.Expect {
+        return false
+    }
+    files, otherFiles := cs.AllFiles(), other.AllFiles()
+    if len(files) != len(otherFiles) {
+        return false
+    }
+    for i := range files {
+        if files[i] != otherFiles[i] {
+            return false
         }
     }
+    return true
+}
+
+func (cs CodeSegment) PrintToScreen() {
+    newPrinter().Print(os.Stdout, cs)
 }
 
 var CodePrefixSpace string = "          " // len:10
@@ -38,20 +83,52 @@ func (cs CodeSegment) PrintToFile(fpath string) error {
     }
     defer f.Close()
 
-    f.WriteString("Id:       " + cs.Id + "\n")
-    f.WriteString("Category: " + cs.Category + "\n")
-    f.WriteString("Tags:     " + cs.Tags + "\n")
-    f.WriteString("Desc:     " + cs.Desc + "\n")
+    cs.writeDoc(f)
+    return nil
+}
 
-    codeLines := strings.Split(cs.Code, "\n")
+func (cs CodeSegment) writeDoc(w io.Writer) {
+    io.WriteString(w, "Id:       "+cs.Id+"\n")
+    io.WriteString(w, "Category: "+cs.Category+"\n")
+    io.WriteString(w, "Tags:     "+cs.Tags+"\n")
+    io.WriteString(w, "Language: "+cs.Language+"\n")
+    io.WriteString(w, "Test:     "+cs.Test+"\n")
+    for i, line := range strings.Split(cs.Expect, "\n") {
+        if i == 0 {
+            io.WriteString(w, "Expect:   "+line+"\n")
+        } else {
+            io.WriteString(w, CodePrefixSpace+line+"\n")
+        }
+    }
+    descLines := strings.Split(cs.Desc, "\n")
+    for i, line := range descLines {
+        if i == 0 {
+            io.WriteString(w, "Desc:     "+line+"\n")
+        } else {
+            io.WriteString(w, CodePrefixSpace+line+"\n")
+        }
+    }
+
+    files := cs.AllFiles()
+    if len(files) == 0 {
+        writeContent(w, cs.Code)
+        return
+    }
+    for _, file := range files {
+        io.WriteString(w, "File:     "+file.Name+"\n")
+        writeContent(w, file.Content)
+    }
+}
+
+func writeContent(w io.Writer, code string) {
+    codeLines := strings.Split(code, "\n")
     for i, line := range codeLines {
         if i == 0 {
-            f.WriteString("Content:  " + line + "\n")
+            io.WriteString(w, "Content:  "+line+"\n")
         } else {
-            f.WriteString(CodePrefixSpace + line + "\n")
+            io.WriteString(w, CodePrefixSpace+line+"\n")
         }
     }
-    return nil
 }
 
 func (cs *CodeSegment) ReadFromFile(fpath string) error {
@@ -61,26 +138,57 @@ func (cs *CodeSegment) ReadFromFile(fpath string) error {
     }
     defer f.Close()
 
-    isCodeLine := false
-    isDescLine := false
+    lines := []string{}
     scanner := bufio.NewScanner(f)
-    //var codePrefixSpace string
     for scanner.Scan() {
-        line := scanner.Text()
+        lines = append(lines, scanner.Text())
+    }
+    cs.parseDoc(lines)
+    return scanner.Err()
+}
+
+func (cs *CodeSegment) parseDoc(lines []string) {
+    isCodeLine := false
+    isDescLine := false
+    isExpectLine := false
+    // code points to cs.Code, or to the content of the current file of a
+    // multi-file segment.
+    code := &cs.Code
+    for _, line := range lines {
         if strings.HasPrefix(line, "Id:") {
             cs.Id = strings.TrimSpace(line[len("Id:"):])
         } else if strings.HasPrefix(line, "Category:") {
             cs.Category = strings.TrimSpace(line[len("Category:"):])
         } else if strings.HasPrefix(line, "Tags:") {
             cs.Tags = strings.TrimSpace(line[len("Tags:"):])
+        } else if strings.HasPrefix(line, "Language:") {
+            cs.Language = strings.TrimSpace(line[len("Language:"):])
+        } else if strings.HasPrefix(line, "Test:") {
+            cs.Test = strings.TrimSpace(line[len("Test:"):])
+            isExpectLine = false
+        } else if strings.HasPrefix(line, "Expect:") {
+            cs.Expect = strings.TrimSpace(line[len("Expect:"):])
+            isExpectLine = true
+            isDescLine = false
+            isCodeLine = false
         } else if strings.HasPrefix(line, "Desc:") {
             cs.Desc = strings.TrimSpace(line[len("Desc:"):])
             isDescLine = true
             isCodeLine = false
+            isExpectLine = false
+        } else if strings.HasPrefix(line, "File:") {
+            cs.Files = append(cs.Files, CodeFile{Name: strings.TrimSpace(line[len("File:"):])})
+            isCodeLine = false
+            isDescLine = false
+            isExpectLine = false
         } else if strings.HasPrefix(line, "Content:") {
-            cs.Code = strings.TrimSpace(line[len("Content:"):])
+            if len(cs.Files) > 0 {
+                code = &cs.Files[len(cs.Files)-1].Content
+            }
+            *code = strings.TrimSpace(line[len("Content:"):])
             isCodeLine = true
             isDescLine = false
+            isExpectLine = false
         } else {
             if isDescLine {
                 var descLine string
@@ -90,7 +198,16 @@ func (cs *CodeSegment) ReadFromFile(fpath string) error {
                     descLine = strings.TrimSpace(line)
                 }
                 cs.Desc = cs.Desc + "\n" + descLine
-                //fmt.Println("cs.Desc:", cs.Desc)
+            }
+
+            if isExpectLine {
+                var expectLine string
+                if strings.HasPrefix(line, CodePrefixSpace) {
+                    expectLine = line[len(CodePrefixSpace):]
+                } else {
+                    expectLine = strings.TrimSpace(line)
+                }
+                cs.Expect = cs.Expect + "\n" + expectLine
             }
 
             if isCodeLine {
@@ -100,24 +217,104 @@ func (cs *CodeSegment) ReadFromFile(fpath string) error {
                 } else {
                     codeLine = strings.TrimSpace(line)
                 }
-                cs.Code = cs.Code + "\n" + codeLine
-                //fmt.Println("cs.Code:", cs.Code)
+                *code = *code + "\n" + codeLine
             }
         }
     }
+
+    if len(cs.Files) > 0 {
+        cs.Code = cs.Files[0].Content
+    }
+    cs.Expect = strings.TrimRight(cs.Expect, "\n")
+}
+
+// bulkDocHeader is written at the top of a bulk edit document. Everything
+// before the first delimiter line is ignored when the document is read back.
+const bulkDocHeader = `# Edit the code segments below, then save and quit.
+# - each segment starts with a delimiter line.
+# - delete a whole segment block to remove the segment.
+# - add a block with an empty Id to create a new segment.
+# - Id is not editable.
+`
+
+// WriteSegmentsToFile serializes segments into one editable document,
+// separated by resultDelimiter lines.
+func WriteSegmentsToFile(fpath string, segs []CodeSegment) error {
+    f, err := os.OpenFile(fpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    io.WriteString(f, bulkDocHeader)
+    for _, cs := range segs {
+        io.WriteString(f, resultDelimiter+"\n")
+        cs.writeDoc(f)
+    }
     return nil
 }
 
+// ReadSegmentsFromFile parses a document written by WriteSegmentsToFile.
+// Empty blocks are skipped.
+func ReadSegmentsFromFile(fpath string) ([]CodeSegment, error) {
+    f, err := os.Open(fpath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    segs := []CodeSegment{}
+    var block []string
+    var flush = func() {
+        if block == nil || strings.TrimSpace(strings.Join(block, "")) == "" {
+            return
+        }
+        var cs CodeSegment
+        cs.parseDoc(block)
+        segs = append(segs, cs)
+    }
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if strings.TrimRight(line, " ") == resultDelimiter {
+            flush()
+            block = []string{}
+            continue
+        }
+        if block != nil {
+            block = append(block, line)
+        }
+    }
+    flush()
+    return segs, scanner.Err()
+}
+
 type Store interface {
-    Add(cs CodeSegment) error
+    // Add stores a new segment and returns the id generated for it.
+    Add(cs CodeSegment) (string, error)
     Update(cs CodeSegment) error
     Append(id string, extraContent string) error
     Search(category string, tagStr string) []CodeSegment
     Remove(id string) error
+    Replace(cs CodeSegment) error
+    Commit(changes ChangeSet) error
     GetById(id string) (CodeSegment, error)
     GetStats() RcsStats
 }
 
+// ChangeSet is a group of adds, updates and removals that a Store applies
+// as one transaction.
+type ChangeSet struct {
+    Added   []CodeSegment
+    Updated []CodeSegment
+    Removed []string
+}
+
+func (c ChangeSet) IsEmpty() bool {
+    return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
 type RcsStats struct {
     TotalRcsSize int
     AllCates     []string
@@ -135,12 +332,29 @@ type FileStore struct {
 func (fs *FileStore) codeSegmentToStr(cs CodeSegment) string {
     descB64 := base64.StdEncoding.EncodeToString([]byte(cs.Desc))
     contentB64 := base64.StdEncoding.EncodeToString([]byte(cs.Code))
-    return cs.Id + "|" + cs.Category + "|" + cs.Tags + "|" + descB64 + "|" + contentB64
+    str := cs.Id + "|" + cs.Category + "|" + cs.Tags + "|" + descB64 + "|" + contentB64 + "|" + cs.Language
+    if len(cs.Files) > 0 || cs.Test != "" || cs.Expect != "" {
+        filesB64 := ""
+        if len(cs.Files) > 0 {
+            // the first file's content is kept in the code field only.
+            files := append([]CodeFile{}, cs.Files...)
+            files[0].Content = ""
+            filesJson, _ := json.Marshal(files)
+            filesB64 = base64.StdEncoding.EncodeToString(filesJson)
+        }
+        str += "|" + filesB64
+    }
+    if cs.Test != "" || cs.Expect != "" {
+        str += "|" + base64.StdEncoding.EncodeToString([]byte(cs.Test)) + "|" + base64.StdEncoding.EncodeToString([]byte(cs.Expect))
+    }
+    return str
 }
 
 func (fs *FileStore) strToCodeSegment(str string) (cs CodeSegment, err error) {
     flds := strings.Split(str, "|")
-    if len(flds) != 5 {
+    // segments saved before the language field was added have 5 fields,
+    // multi-file segments have 7 and testable segments 9.
+    if len(flds) < 5 || len(flds) == 8 || len(flds) > 9 {
         err = errors.New("parse segemnt str failed: " + str)
         return
     }
@@ -149,6 +363,21 @@ func (fs *FileStore) strToCodeSegment(str string) (cs CodeSegment, err error) {
     tags := flds[2]
     desc := flds[3]
     code := flds[4]
+    lang := ""
+    if len(flds) >= 6 {
+        lang = flds[5]
+    }
+
+    var files []CodeFile
+    if len(flds) >= 7 && flds[6] != "" {
+        filesJson, err := base64.StdEncoding.DecodeString(flds[6])
+        if err != nil {
+            return cs, err
+        }
+        if err = json.Unmarshal(filesJson, &files); err != nil {
+            return cs, err
+        }
+    }
 
     descBs, err := base64.StdEncoding.DecodeString(desc)
     if err != nil {
@@ -163,7 +392,17 @@ func (fs *FileStore) strToCodeSegment(str string) (cs CodeSegment, err error) {
     desc = string(descBs)
     code = string(codeBs)
 
-    cs = CodeSegment{id, cate, tags, desc, code}
+    var test, expect []byte
+    if len(flds) == 9 {
+        if test, err = base64.StdEncoding.DecodeString(flds[7]); err != nil {
+            return
+        }
+        if expect, err = base64.StdEncoding.DecodeString(flds[8]); err != nil {
+            return
+        }
+    }
+
+    cs = CodeSegment{id, cate, tags, desc, code, lang, files, string(test), string(expect)}
     return
 }
 
@@ -183,29 +422,29 @@ func (fs *FileStore) genId(cs CodeSegment) (id string, err error) {
     return
 }
 
-func (fs *FileStore) Add(cs CodeSegment) error {
+func (fs *FileStore) Add(cs CodeSegment) (string, error) {
     if cs.Id == "" {
         id, err := fs.genId(cs)
         if err != nil {
-            return err
+            return "", err
         }
         //fmt.Println("id: ", id, "id len:", len(id))
         cs.Id = id
     }
 
     if err := fs.isDuplicate(cs); err != nil {
-        return err
+        return "", err
     }
 
     f, err := os.OpenFile(fs.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0660)
     if err != nil {
-        return err
+        return "", err
     }
     defer f.Close()
 
     line := fs.codeSegmentToStr(cs)
     _, err = f.WriteString(line + "\n")
-    return err
+    return cs.Id, err
 }
 
 func (fs *FileStore) GetById(id string) (cs CodeSegment, err error) {
@@ -254,8 +493,21 @@ func (fs *FileStore) Update(cs CodeSegment) error {
         newCs.Code = cs.Code
     }
 
+    if cs.Language != "" {
+        newCs.Language = cs.Language
+    }
+
+    if cs.Test != "" {
+        newCs.Test = cs.Test
+    }
+
+    if cs.Expect != "" {
+        newCs.Expect = cs.Expect
+    }
+
     fs.Remove(cs.Id)
-    return fs.Add(newCs)
+    _, err = fs.Add(newCs)
+    return err
 }
 
 func (fs *FileStore) Append(id string, extraContent string) error {
@@ -266,7 +518,8 @@ func (fs *FileStore) Append(id string, extraContent string) error {
 
     newCs.Code = strings.Trim(newCs.Code, "\n") + "\n" + strings.Trim(extraContent, "\n")
     fs.Remove(id)
-    return fs.Add(newCs)
+    _, err = fs.Add(newCs)
+    return err
 }
 
 func (fs *FileStore) Search(category string, tagStr string) []CodeSegment {
@@ -292,6 +545,12 @@ func grepFile(file string, reqCate string, reqTagStr string) []string {
         cateInStore = strings.ToUpper(cateInStore)
         cateReq = strings.ToUpper(cateReq)
 
+        // hierarchical category: go matches go/strings and go/net/http.
+        if IsSubCategory(cateInStore, cateReq) {
+            return true
+        }
+
+        // legacy hyphenated category: go-strings matches go and strings.
         cates := strings.Split(cateInStore, "-")
         for _, cate := range cates {
             if cate == cateReq {
@@ -311,7 +570,7 @@ func grepFile(file string, reqCate string, reqTagStr string) []string {
 
         allTagsOfCs := strings.Split(tagsInStore, ",")
         for _, t := range allTagsOfCs {
-            subTs := strings.Split(t, "-")
+            subTs := strings.FieldsFunc(t, func(r rune) bool { return r == '-' || r == CategorySep })
             if len(subTs) > 1 {
                 for _, subTag := range subTs {
                     allTagsOfCs = append(allTagsOfCs, subTag)
@@ -340,7 +599,10 @@ func grepFile(file string, reqCate string, reqTagStr string) []string {
     res := []string{}
     f, err := os.Open(file)
     if err != nil {
-        fmt.Println(err)
+        // no segment file yet is an empty store.
+        if !os.IsNotExist(err) {
+            fmt.Println(err)
+        }
         return res
     }
     defer f.Close()
@@ -366,53 +628,125 @@ func (fs *FileStore) Remove(id string) error {
         return errors.New("Invalid id, id is too short")
     }
 
-    f, err := os.Open(fs.FilePath)
+    lines, err := fs.readLines()
     if err != nil {
         return err
     }
-    defer f.Close()
 
-    scanner := bufio.NewScanner(f)
     fLines := []string{}
-    for scanner.Scan() {
-        line := scanner.Text()
+    for _, line := range lines {
         if strings.HasPrefix(line, id) {
             continue
         }
         fLines = append(fLines, line)
     }
 
-    oldFilePath := fs.FilePath + ".old"
-    os.Remove(oldFilePath)
-    err = os.Rename(fs.FilePath, oldFilePath)
+    return fs.writeLines(fLines)
+}
+
+// Replace overwrites the segment with the same id in place, so the segment
+// keeps both its id and its position in the store file.
+func (fs *FileStore) Replace(cs CodeSegment) error {
+    if len(cs.Id) < IdLen {
+        return errors.New("invalid id:" + cs.Id)
+    }
+
+    lines, err := fs.readLines()
     if err != nil {
-        fmt.Println(err.Error())
+        return err
+    }
+
+    found := false
+    for i, line := range lines {
+        if strings.HasPrefix(line, cs.Id) {
+            lines[i] = fs.codeSegmentToStr(cs)
+            found = true
+            break
+        }
+    }
+
+    if !found {
+        return errors.New("can not find code-segment by id:" + cs.Id)
+    }
+    return fs.writeLines(lines)
+}
+
+func (fs *FileStore) readLines() ([]string, error) {
+    f, err := os.Open(fs.FilePath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    lines := []string{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+    return lines, scanner.Err()
+}
+
+// writeLines replaces the store file with lines, keeping the previous
+// version as FilePath + ".old". The lines are written to a temp file that
+// is renamed over the store file, so a failed write leaves it untouched.
+func (fs *FileStore) writeLines(fLines []string) error {
+    previous, err := ioutil.ReadFile(fs.FilePath)
+    if err != nil && !os.IsNotExist(err) {
+        return err
     }
 
-    newFile, err := os.OpenFile(fs.FilePath, os.O_CREATE|os.O_WRONLY, 0660)
+    tmpFile, err := ioutil.TempFile(filepath.Dir(fs.FilePath), filepath.Base(fs.FilePath)+".tmp")
     if err != nil {
         return err
     }
-    defer newFile.Close()
+    // a no-op once the temp file is renamed.
+    defer os.Remove(tmpFile.Name())
+
+    w := bufio.NewWriter(tmpFile)
     for _, line := range fLines {
-        newFile.WriteString(line + "\n")
+        if _, err = w.WriteString(line + "\n"); err != nil {
+            break
+        }
+    }
+    if err == nil {
+        err = w.Flush()
+    }
+    if err == nil {
+        err = tmpFile.Sync()
+    }
+    if closeErr := tmpFile.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Chmod(tmpFile.Name(), 0660)
+    }
+    if err != nil {
+        return err
+    }
+    if err = os.Rename(tmpFile.Name(), fs.FilePath); err != nil {
+        return err
     }
 
+    if previous != nil {
+        if err = ioutil.WriteFile(fs.FilePath+".old", previous, 0660); err != nil {
+            fmt.Println("can not keep the previous store file:", err.Error())
+        }
+    }
     return nil
 }
 
 func (fs *FileStore) isDuplicate(cs CodeSegment) error {
-    f, err := os.Open(fs.FilePath)
+    lines, err := fs.readLines()
     if err != nil {
         return nil
     }
-    defer f.Close()
+    return fs.duplicateIn(lines, cs)
+}
 
-    scanner := bufio.NewScanner(f)
-    for scanner.Scan() {
-        line := scanner.Text()
+func (fs *FileStore) duplicateIn(lines []string, cs CodeSegment) error {
+    for _, line := range lines {
         csInFile, _ := fs.strToCodeSegment(line)
-        if csInFile.Code == cs.Code {
+        if contentHash(csInFile) == contentHash(cs) {
             return errors.New("duplicated code content with id:" + csInFile.Id)
         }
         if csInFile.Id == cs.Id {
@@ -422,6 +756,59 @@ func (fs *FileStore) isDuplicate(cs CodeSegment) error {
     return nil
 }
 
+// Commit applies all changes with a single rewrite of the store file. If any
+// change fails, the store file is left untouched.
+func (fs *FileStore) Commit(changes ChangeSet) error {
+    lines, err := fs.readLines()
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    var indexOf = func(id string) int {
+        if len(id) < IdLen {
+            return -1
+        }
+        for i, line := range lines {
+            if strings.HasPrefix(line, id) {
+                return i
+            }
+        }
+        return -1
+    }
+
+    for _, cs := range changes.Updated {
+        i := indexOf(cs.Id)
+        if i < 0 {
+            return errors.New("can not find code-segment by id:" + cs.Id)
+        }
+        lines[i] = fs.codeSegmentToStr(cs)
+    }
+
+    for _, id := range changes.Removed {
+        i := indexOf(id)
+        if i < 0 {
+            return errors.New("can not find code-segment by id:" + id)
+        }
+        lines = append(lines[:i], lines[i+1:]...)
+    }
+
+    for _, cs := range changes.Added {
+        if cs.Id == "" {
+            id, err := fs.genId(cs)
+            if err != nil {
+                return err
+            }
+            cs.Id = id
+        }
+        if err := fs.duplicateIn(lines, cs); err != nil {
+            return err
+        }
+        lines = append(lines, fs.codeSegmentToStr(cs))
+    }
+
+    return fs.writeLines(lines)
+}
+
 func (fs *FileStore) GetStats() RcsStats {
     stats := RcsStats{
         AllCates:    []string{},
@@ -434,7 +821,9 @@ func (fs *FileStore) GetStats() RcsStats {
 
     f, err := os.Open(fs.FilePath)
     if err != nil {
-        fmt.Println(err)
+        if !os.IsNotExist(err) {
+            fmt.Println(err)
+        }
         return stats
     }
 
@@ -0,0 +1,241 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const collectionFileName = "collections.rcs"
+
+// PinnedCollection is the collection rcs pin adds to.
+const PinnedCollection = "pinned"
+
+// Collections are named lists of segments, independent of categories and
+// tags, stored in the codebase as lines of "name=id1,id2".
+type Collections struct {
+    FilePath string
+    ids      map[string][]string
+}
+
+func LoadCollections(fpath string) (*Collections, error) {
+    c := &Collections{fpath, map[string][]string{}}
+    f, err := os.Open(fpath)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return c, nil
+        }
+        return c, err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        flds := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+        if len(flds) == 2 && flds[1] != "" {
+            c.ids[flds[0]] = strings.Split(flds[1], ",")
+        }
+    }
+    return c, scanner.Err()
+}
+
+func (c *Collections) save() error {
+    f, err := os.OpenFile(c.FilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    for _, name := range c.Names() {
+        if _, err = f.WriteString(name + "=" + strings.Join(c.ids[name], ",") + "\n"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Names returns the names of all collections in sorted order.
+func (c *Collections) Names() []string {
+    names := []string{}
+    for name := range c.ids {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Forget drops removed segment id from all collections.
+func (c *Collections) Forget(id string) error {
+    changed := false
+    for name, ids := range c.ids {
+        if ArrContains(ids, id) {
+            c.ids[name] = removeString(ids, id)
+            if len(c.ids[name]) == 0 {
+                delete(c.ids, name)
+            }
+            changed = true
+        }
+    }
+    if !changed {
+        return nil
+    }
+    return c.save()
+}
+
+func removeString(arr []string, str string) []string {
+    res := []string{}
+    for _, s := range arr {
+        if s != str {
+            res = append(res, s)
+        }
+    }
+    return res
+}
+
+func validateCollectionName(name string) error {
+    if name == "" || strings.ContainsAny(name, "=, \t\n") {
+        return errors.New("invalid collection name: " + name)
+    }
+    return nil
+}
+
+// CollectionAdd adds segments refs to collection name, creating it.
+func (op *Operator) CollectionAdd(name string, refs ...string) {
+    if op.err != nil {
+        return
+    }
+    if op.err = validateCollectionName(name); op.err != nil {
+        return
+    }
+
+    ids := op.collections.ids[name]
+    for _, ref := range refs {
+        cs, err := op.getByRef(ref)
+        if err != nil {
+            op.err = err
+            return
+        }
+        if !ArrContains(ids, cs.Id) {
+            ids = append(ids, cs.Id)
+        }
+    }
+    op.collections.ids[name] = ids
+    op.err = op.collections.save()
+}
+
+// CollectionRemove removes segments refs from collection name, and the
+// collection itself once it is empty or if no refs are given.
+func (op *Operator) CollectionRemove(name string, refs ...string) {
+    if op.err != nil {
+        return
+    }
+    ids, ok := op.collections.ids[name]
+    if !ok {
+        op.err = errors.New("collection not found: " + name)
+        return
+    }
+
+    if len(refs) == 0 {
+        ids = nil
+    }
+    for _, ref := range refs {
+        id := ref
+        if op.idAliases != nil {
+            id = op.idAliases.Resolve(ref)
+        }
+        if !ArrContains(ids, id) {
+            op.err = errors.New(ref + " is not in collection " + name + ".")
+            return
+        }
+        ids = removeString(ids, id)
+    }
+
+    if len(ids) == 0 {
+        delete(op.collections.ids, name)
+    } else {
+        op.collections.ids[name] = ids
+    }
+    op.err = op.collections.save()
+}
+
+// ListCollections prints the collections with their number of segments.
+func (op *Operator) ListCollections() {
+    head := []string{"COLLECTION              ", "RCS-NUM"}
+    format := fmt.Sprintf("%%-%ds%%s\n", len(head[0]))
+    fmt.Print(strings.Join(head, "") + "\n")
+    for _, name := range op.collections.Names() {
+        fmt.Printf(format, name, strconv.Itoa(len(op.collections.ids[name])))
+    }
+}
+
+// collectionSegments returns the segments of collection name in the order
+// they were added. Ids no longer in the store are reported and skipped.
+func (op *Operator) collectionSegments(name string) ([]CodeSegment, error) {
+    ids, ok := op.collections.ids[name]
+    if !ok {
+        return nil, errors.New("collection not found: " + name)
+    }
+    segs := []CodeSegment{}
+    for _, id := range ids {
+        cs, err := op.store.GetById(id)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "skip %s in collection %s: %s\n", id, name, err.Error())
+            continue
+        }
+        segs = append(segs, cs)
+    }
+    return segs, nil
+}
+
+// ShowCollection prints the segments of collection name.
+func (op *Operator) ShowCollection(name string) {
+    if op.err != nil {
+        return
+    }
+    segs, err := op.collectionSegments(name)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    printer := newPrinter()
+    for _, cs := range segs {
+        fmt.Println(resultDelimiter)
+        printer.Print(os.Stdout, cs)
+    }
+    fmt.Println(resultDelimiter)
+}
+
+// ExportCollection writes the segments of collection name to fpath, or to
+// stdout if fpath is empty, in the edit document format.
+func (op *Operator) ExportCollection(name string, fpath string) {
+    if op.err != nil {
+        return
+    }
+    segs, err := op.collectionSegments(name)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    var w io.Writer = os.Stdout
+    if fpath != "" {
+        f, err := os.OpenFile(fpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+        if err != nil {
+            op.err = err
+            return
+        }
+        defer f.Close()
+        w = f
+    }
+    io.WriteString(w, "# collection: "+name+"\n")
+    for _, cs := range segs {
+        io.WriteString(w, resultDelimiter+"\n")
+        cs.writeDoc(w)
+    }
+}
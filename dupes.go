@@ -0,0 +1,257 @@
+package main
+
+import (
+    "crypto/sha1"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// NearDupThreshold is the estimated similarity from which a segment is
+// reported as a near-duplicate of another.
+const NearDupThreshold = 0.7
+
+const (
+    minHashSize  = 64
+    shingleWidth = 3
+)
+
+// minHashSeeds are the parameters of the minHashSize hash functions,
+// fixed so signatures are comparable between runs.
+var minHashSeeds = func() [minHashSize][2]uint64 {
+    var seeds [minHashSize][2]uint64
+    x := uint64(0x9e3779b97f4a7c15)
+    for i := range seeds {
+        for j := range seeds[i] {
+            // xorshift64
+            x ^= x << 13
+            x ^= x >> 7
+            x ^= x << 17
+            seeds[i][j] = x | 1
+        }
+    }
+    return seeds
+}()
+
+var codeTokenPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*|[0-9]+|[^\sA-Za-z0-9_]`)
+
+// normalizeCode collapses white space and drops blank lines, so code
+// differing only in formatting normalizes the same.
+func normalizeCode(code string) string {
+    lines := []string{}
+    for _, line := range strings.Split(code, "\n") {
+        if line = strings.Join(strings.Fields(line), " "); line != "" {
+            lines = append(lines, line)
+        }
+    }
+    return strings.Join(lines, "\n")
+}
+
+// contentHash returns the hash of the normalized code and files of cs.
+func contentHash(cs CodeSegment) string {
+    h := sha1.New()
+    h.Write([]byte(normalizeCode(cs.Code)))
+    for _, file := range cs.AllFiles() {
+        h.Write([]byte("\x00" + file.Name + "\x00" + normalizeCode(file.Content)))
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// minHash returns the MinHash signature of the token shingles of the code
+// of cs.
+func minHash(cs CodeSegment) []uint64 {
+    code := cs.Code
+    for _, file := range cs.AllFiles()[minInt(1, len(cs.Files)):] {
+        code += "\n" + file.Content
+    }
+    tokens := codeTokenPattern.FindAllString(strings.ToLower(code), -1)
+
+    shingles := map[uint64]bool{}
+    for i := 0; i == 0 || i+shingleWidth <= len(tokens); i++ {
+        h := fnv.New64a()
+        h.Write([]byte(strings.Join(tokens[i:minInt(i+shingleWidth, len(tokens))], " ")))
+        shingles[h.Sum64()] = true
+    }
+
+    sig := make([]uint64, minHashSize)
+    for i := range sig {
+        sig[i] = ^uint64(0)
+        for s := range shingles {
+            if v := s*minHashSeeds[i][0] + minHashSeeds[i][1]; v < sig[i] {
+                sig[i] = v
+            }
+        }
+    }
+    return sig
+}
+
+// similarity estimates the Jaccard similarity of two signatures.
+func similarity(a []uint64, b []uint64) float64 {
+    same := 0
+    for i := range a {
+        if a[i] == b[i] {
+            same++
+        }
+    }
+    return float64(same) / float64(len(a))
+}
+
+// nearDuplicates returns the segments of segs similar to cs, most similar
+// first, with their similarity.
+func nearDuplicates(cs CodeSegment, segs []CodeSegment) ([]CodeSegment, []float64) {
+    sig, hash := minHash(cs), contentHash(cs)
+    type scored struct {
+        cs    CodeSegment
+        score float64
+    }
+    found := []scored{}
+    for _, other := range segs {
+        // exact duplicates are rejected by the store.
+        if other.Id == cs.Id || contentHash(other) == hash {
+            continue
+        }
+        if score := similarity(sig, minHash(other)); score >= NearDupThreshold {
+            found = append(found, scored{other, score})
+        }
+    }
+    sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+
+    res, scores := []CodeSegment{}, []float64{}
+    for _, f := range found {
+        res = append(res, f.cs)
+        scores = append(scores, f.score)
+    }
+    return res, scores
+}
+
+func percent(score float64) string {
+    return strconv.Itoa(int(score*100+0.5)) + "%"
+}
+
+// checkNearDuplicates warns about segments similar to cs, and on a
+// terminal asks whether to add it anyway.
+func (op *Operator) checkNearDuplicates(cs CodeSegment) error {
+    dups, scores := nearDuplicates(cs, op.store.Search("", ""))
+    if len(dups) == 0 {
+        return nil
+    }
+
+    for i, dup := range dups {
+        fmt.Printf("warning: similar to code segment %s (%s), category: %s, tags: %s\n", dup.Id, percent(scores[i]), dup.Category, dup.Tags)
+    }
+    if isTerminal(os.Stdin) && !askYesNo("add anyway?") {
+        return errors.New("add aborted.")
+    }
+    return nil
+}
+
+// Dupes prints the clusters of similar segments with a suggested merge.
+func (op *Operator) Dupes() {
+    if op.err != nil {
+        return
+    }
+
+    segs := op.store.Search("", "")
+    sigs := make([][]uint64, len(segs))
+    for i, cs := range segs {
+        sigs[i] = minHash(cs)
+    }
+
+    // union-find of the pairs above the threshold.
+    parent := make([]int, len(segs))
+    for i := range parent {
+        parent[i] = i
+    }
+    var find func(int) int
+    find = func(i int) int {
+        if parent[i] != i {
+            parent[i] = find(parent[i])
+        }
+        return parent[i]
+    }
+    for i := range segs {
+        for j := i + 1; j < len(segs); j++ {
+            if similarity(sigs[i], sigs[j]) >= NearDupThreshold {
+                parent[find(j)] = find(i)
+            }
+        }
+    }
+
+    clusters := map[int][]int{}
+    roots := []int{}
+    for i := range segs {
+        root := find(i)
+        if _, ok := clusters[root]; !ok {
+            roots = append(roots, root)
+        }
+        clusters[root] = append(clusters[root], i)
+    }
+
+    count := 0
+    for _, root := range roots {
+        members := clusters[root]
+        if len(members) < 2 {
+            continue
+        }
+        count++
+
+        // the longest code is kept, the others are folded into it.
+        keep := members[0]
+        for _, m := range members {
+            if len(segs[m].Code) > len(segs[keep].Code) {
+                keep = m
+            }
+        }
+
+        fmt.Printf("cluster %d, %d segments:\n", count, len(members))
+        tags := splitTags(segs[keep].Tags)
+        // tags of the others, ranked by how many of them carry each.
+        folded, carriers := []string{}, map[string]int{}
+        removes := []string{}
+        for _, m := range members {
+            cs := segs[m]
+            mark := "  "
+            if m == keep {
+                mark = "* "
+            }
+            fmt.Printf("  %s%s  %4s  %s  %s\n", mark, cs.Id, percent(similarity(sigs[keep], sigs[m])), cs.Category, cs.Tags)
+            if m == keep {
+                continue
+            }
+            for _, t := range splitTags(cs.Tags) {
+                if ArrContains(tags, t) {
+                    continue
+                }
+                if carriers[t] == 0 {
+                    folded = append(folded, t)
+                }
+                carriers[t]++
+            }
+            removes = append(removes, cs.Id)
+        }
+        sort.SliceStable(folded, func(i, j int) bool { return carriers[folded[i]] > carriers[folded[j]] })
+        tags = append(tags, folded...)
+        if len(tags) > MaxTags {
+            fmt.Printf("  %d tags, only the first %d are suggested: %s dropped\n", len(tags), MaxTags, strings.Join(tags[MaxTags:], ","))
+            tags = tags[:MaxTags]
+        }
+
+        fmt.Println("  suggested merge, keep * and fold the tags of the others into it:")
+        if tagStr := strings.Join(tags, ","); tagStr != segs[keep].Tags {
+            fmt.Printf("    rcs update -i %s -t %s\n", segs[keep].Id, tagStr)
+        }
+        for _, id := range removes {
+            fmt.Printf("    rcs remove %s\n", id)
+        }
+    }
+
+    if count == 0 {
+        fmt.Println("no near-duplicate code segments.")
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "go/ast"
+    "go/format"
+    "go/parser"
+    "go/scanner"
+    "go/token"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// GoCheck controls validation of Go segments on add and edit, off unless
+// asked for with --check or --gofmt.
+type GoCheck struct {
+    Enabled bool
+    // Format applies go/format without asking.
+    Format bool
+}
+
+// goWrapper makes a code snippet a parsable Go file. header is the number
+// of lines added before the snippet.
+type goWrapper struct {
+    prefix, suffix string
+    header         int
+}
+
+// goWrappers are tried in order: a full file, declarations, statements or
+// expressions in a function body, and struct fields.
+var goWrappers = []goWrapper{
+    {"", "", 0},
+    {"package p\n", "", 1},
+    {"package p\nfunc _() {\n", "\n}\n", 2},
+    {"package p\ntype _ struct {\n", "\n}\n", 2},
+}
+
+// CheckGoCode parses code as a Go file, declarations, statements or struct
+// fields, and returns it formatted by go/format. Syntax errors are
+// reported with line numbers of code.
+func CheckGoCode(code string) (string, error) {
+    for _, w := range goWrappers {
+        src := w.prefix + code + w.suffix
+        if _, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments); err != nil {
+            continue
+        }
+
+        formatted, err := format.Source([]byte(src))
+        if err != nil {
+            return code, nil
+        }
+        return unwrapGo(string(formatted), w), nil
+    }
+
+    // report the error of the form the code most likely has.
+    w := goWrappers[2]
+    first := strings.TrimSpace(code)
+    if strings.HasPrefix(first, "package") {
+        w = goWrappers[0]
+    } else {
+        for _, kw := range []string{"func ", "type ", "var ", "const ", "import "} {
+            if strings.HasPrefix(first, kw) {
+                w = goWrappers[1]
+            }
+        }
+    }
+    _, err := parser.ParseFile(token.NewFileSet(), "", w.prefix+code+w.suffix, 0)
+    return code, goSyntaxError(err, w.header, strings.Count(code, "\n")+1)
+}
+
+// unwrapGo removes the lines added by w from formatted source.
+func unwrapGo(formatted string, w goWrapper) string {
+    if w.header == 0 {
+        return strings.TrimRight(formatted, "\n")
+    }
+
+    lines := strings.Split(strings.TrimRight(formatted, "\n"), "\n")
+    // go/format may add a blank line after the package clause.
+    start, end := 1, len(lines)
+    for start < end && lines[start] == "" {
+        start++
+    }
+    if w.suffix == "" {
+        return strings.Join(lines[start:], "\n")
+    }
+
+    // the wrapper indents the snippet one tab, except the lines inside raw
+    // strings which go/format leaves as they are.
+    inRaw := rawStringLines(formatted)
+    res := []string{}
+    for i := start + 1; i < end-1; i++ {
+        line := lines[i]
+        if !inRaw[i+1] {
+            line = strings.TrimPrefix(line, "\t")
+        }
+        res = append(res, line)
+    }
+    return strings.Join(res, "\n")
+}
+
+// rawStringLines returns the line numbers of src that continue a raw
+// string literal.
+func rawStringLines(src string) map[int]bool {
+    lines := map[int]bool{}
+    fset := token.NewFileSet()
+    f, err := parser.ParseFile(fset, "", src, 0)
+    if err != nil {
+        return lines
+    }
+    ast.Inspect(f, func(n ast.Node) bool {
+        if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING && strings.HasPrefix(lit.Value, "`") {
+            for l := fset.Position(lit.Pos()).Line + 1; l <= fset.Position(lit.End()).Line; l++ {
+                lines[l] = true
+            }
+        }
+        return true
+    })
+    return lines
+}
+
+// goSyntaxError rewrites parser errors with line numbers of the snippet,
+// errors in the wrapper lines are reported at the first or last line.
+func goSyntaxError(err error, header int, lines int) error {
+    list, ok := err.(scanner.ErrorList)
+    if !ok {
+        return err
+    }
+
+    msgs := []string{}
+    for i, e := range list {
+        line := e.Pos.Line - header
+        if line < 1 || line > lines {
+            // follow-up errors caused by the wrapper only add noise.
+            if i > 0 {
+                continue
+            }
+            line = minInt(maxInt(line, 1), lines)
+        }
+        msgs = append(msgs, "  line "+strconv.Itoa(line)+": "+e.Msg)
+    }
+    return errors.New("go syntax error:\n" + strings.Join(msgs, "\n"))
+}
+
+// askYesNo asks question on the terminal, false if stdin is not one.
+func askYesNo(question string) bool {
+    if !isTerminal(os.Stdin) {
+        return false
+    }
+    fmt.Print(question + "  yes|no ")
+    answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+    return strings.ToUpper(strings.TrimSpace(answer)) == "YES"
+}
+
+// checkGo validates the Go code of cs, and of each .go file of a
+// multi-file segment, and offers go/format formatting.
+func (op *Operator) checkGo(cs CodeSegment) (CodeSegment, error) {
+    return op.formatGo(cs, func(name string) bool {
+        return op.goCheck.Format || askYesNo(name+" is not gofmt formatted, format it?")
+    })
+}
+
+// formatGo is checkGo without prompts, format is called with the name of
+// each unformatted file and tells whether to format it.
+func (op *Operator) formatGo(cs CodeSegment, format func(name string) bool) (CodeSegment, error) {
+    if !op.goCheck.Enabled {
+        return cs, nil
+    }
+
+    var check = func(name string, code string) (string, error) {
+        // templates and includes are only valid go once expanded.
+        if len(Placeholders(code)) > 0 || len(includeRefs(code)) > 0 {
+            return code, nil
+        }
+        formatted, err := CheckGoCode(code)
+        if err != nil {
+            return code, errors.New(name + ": " + err.Error())
+        }
+        if formatted == code {
+            return code, nil
+        }
+        if format(name) {
+            return formatted, nil
+        }
+        return code, nil
+    }
+
+    files := cs.AllFiles()
+    if len(files) == 0 {
+        if cs.Language != "go" {
+            return cs, nil
+        }
+        code, err := check("code", cs.Code)
+        cs.Code = code
+        return cs, err
+    }
+
+    for i, file := range files {
+        if !strings.HasSuffix(file.Name, ".go") {
+            continue
+        }
+        code, err := check(file.Name, file.Content)
+        if err != nil {
+            return cs, err
+        }
+        cs.Files[i].Content = code
+        if i == 0 {
+            cs.Code = code
+        }
+    }
+    return cs, nil
+}
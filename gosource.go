@@ -0,0 +1,85 @@
+package main
+
+import (
+    "errors"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "io/ioutil"
+    "strings"
+)
+
+// ExtractGoSymbol returns the source and doc comment of the function, type,
+// or method named symbol in Go file fpath. A method is named Type.Method.
+func ExtractGoSymbol(fpath string, symbol string) (code string, doc string, err error) {
+    src, err := ioutil.ReadFile(fpath)
+    if err != nil {
+        return
+    }
+
+    fset := token.NewFileSet()
+    f, err := parser.ParseFile(fset, fpath, src, parser.ParseComments)
+    if err != nil {
+        return
+    }
+
+    recv, name := "", symbol
+    if i := strings.Index(symbol, "."); i >= 0 {
+        recv, name = symbol[:i], symbol[i+1:]
+    }
+
+    var source = func(from token.Pos, to token.Pos) string {
+        return string(src[fset.Position(from).Offset:fset.Position(to).Offset])
+    }
+
+    for _, decl := range f.Decls {
+        switch d := decl.(type) {
+        case *ast.FuncDecl:
+            if d.Name.Name != name || receiverName(d) != recv {
+                continue
+            }
+            return source(d.Pos(), d.End()), d.Doc.Text(), nil
+        case *ast.GenDecl:
+            if d.Tok != token.TYPE || recv != "" {
+                continue
+            }
+            for _, spec := range d.Specs {
+                ts := spec.(*ast.TypeSpec)
+                if ts.Name.Name != name {
+                    continue
+                }
+                if d.Lparen == token.NoPos {
+                    return source(d.Pos(), d.End()), d.Doc.Text(), nil
+                }
+                // a spec of a grouped declaration: type ( ... ).
+                return "type " + source(ts.Pos(), ts.End()), ts.Doc.Text(), nil
+            }
+        }
+    }
+
+    err = errors.New("can not find " + symbol + " in " + fpath)
+    return
+}
+
+// receiverName returns the receiver type name of a method, without the
+// pointer, or "" for a function.
+func receiverName(fd *ast.FuncDecl) string {
+    if fd.Recv == nil || len(fd.Recv.List) == 0 {
+        return ""
+    }
+    typ := fd.Recv.List[0].Type
+    if star, ok := typ.(*ast.StarExpr); ok {
+        typ = star.X
+    }
+    // generic receivers, eg: List[T].
+    if index, ok := typ.(*ast.IndexExpr); ok {
+        typ = index.X
+    }
+    if index, ok := typ.(*ast.IndexListExpr); ok {
+        typ = index.X
+    }
+    if ident, ok := typ.(*ast.Ident); ok {
+        return ident.Name
+    }
+    return ""
+}
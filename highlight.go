@@ -0,0 +1,350 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// token kinds used for highlighting.
+const (
+    tokPlain = iota
+    tokKeyword
+    tokString
+    tokComment
+    tokNumber
+    tokMatch
+)
+
+// Theme maps token kinds to ANSI escape sequences.
+type Theme struct {
+    Keyword, String, Comment, Number, Match, LineNumber, Label string
+}
+
+func (t Theme) style(kind int) string {
+    switch kind {
+    case tokKeyword:
+        return t.Keyword
+    case tokString:
+        return t.String
+    case tokComment:
+        return t.Comment
+    case tokNumber:
+        return t.Number
+    case tokMatch:
+        return t.Match
+    }
+    return ""
+}
+
+// Themes are selected by $RCS_THEME, default is dark.
+var Themes = map[string]Theme{
+    "dark":  {"\x1b[35m", "\x1b[32m", "\x1b[90m", "\x1b[36m", "\x1b[1;30;43m", "\x1b[90m", "\x1b[1m"},
+    "light": {"\x1b[34m", "\x1b[31m", "\x1b[90m", "\x1b[35m", "\x1b[1;43m", "\x1b[90m", "\x1b[1m"},
+    "mono":  {"\x1b[1m", "", "\x1b[2m", "", "\x1b[7m", "\x1b[2m", "\x1b[1m"},
+}
+
+const ansiReset = "\x1b[0m"
+
+// colorEnabled reports whether output to stdout should be colored. Color is
+// off if stdout is not a terminal or $NO_COLOR is set.
+func colorEnabled() bool {
+    if _, ok := os.LookupEnv("NO_COLOR"); ok {
+        return false
+    }
+    return stdoutTTY
+}
+
+// Printer prints code segments, with syntax highlighting and line numbers
+// when Color is on.
+type Printer struct {
+    Color bool
+    Theme Theme
+    // Terms are highlighted wherever they appear, eg: the tags searched for.
+    Terms []string
+}
+
+func newPrinter() *Printer {
+    theme, ok := Themes[os.Getenv("RCS_THEME")]
+    if !ok {
+        theme = Themes["dark"]
+    }
+    return &Printer{colorEnabled(), theme, nil}
+}
+
+func (p *Printer) label(s string) string {
+    if !p.Color {
+        return s
+    }
+    return p.Theme.Label + s + ansiReset
+}
+
+// Print writes cs to w in the same layout as CodeSegment.PrintToScreen.
+func (p *Printer) Print(w io.Writer, cs CodeSegment) {
+    fmt.Fprintf(w, "%s %s\n", p.label("  ID:"), cs.Id)
+    fmt.Fprintf(w, "%s %s\n", p.label("CATE:"), p.highlightTerms(cs.Category))
+    fmt.Fprintf(w, "%s %s\n", p.label("TAGS:"), p.highlightTerms(cs.Tags))
+    fmt.Fprintf(w, "%s %s\n", p.label("LANG:"), cs.Language)
+    fmt.Fprintf(w, "%s %s\n", p.label("DESC:"), p.highlightTerms(cs.Desc))
+    if cs.Test != "" || cs.Expect != "" {
+        fmt.Fprintf(w, "%s %s\n", p.label("TEST:"), cs.Test)
+        fmt.Fprintf(w, "%s %s\n", p.label("EXPECT:"), strings.Replace(cs.Expect, "\n", "\n        ", -1))
+    }
+    files := cs.AllFiles()
+    if len(files) == 0 {
+        fmt.Fprintln(w, p.label("CONTENT:"))
+        p.printCode(w, cs.Code, cs.EffectiveLanguage())
+        return
+    }
+    for _, file := range files {
+        fmt.Fprintf(w, "%s %s\n", p.label("FILE:"), file.Name)
+        p.printCode(w, file.Content, LanguageOfFile(file.Name, file.Content))
+    }
+}
+
+func (p *Printer) printCode(w io.Writer, code string, lang string) {
+    if !p.Color {
+        for _, line := range strings.Split(code, "\n") {
+            fmt.Fprintln(w, "      "+line)
+        }
+        return
+    }
+
+    hl := newHighlighter(lang)
+    for i, line := range strings.Split(code, "\n") {
+        kinds := hl.tokenize(line)
+        p.markTerms(line, kinds)
+        fmt.Fprintf(w, "%s%4d%s  %s\n", p.Theme.LineNumber, i+1, ansiReset, p.render(line, kinds))
+    }
+}
+
+// highlightTerms highlights Terms in a plain text line.
+func (p *Printer) highlightTerms(s string) string {
+    if !p.Color || len(p.Terms) == 0 {
+        return s
+    }
+    kinds := make([]int, len([]rune(s)))
+    p.markTerms(s, kinds)
+    return p.render(s, kinds)
+}
+
+// markTerms sets the kind of every rune of line that is part of a term to
+// tokMatch. Terms are matched case insensitively.
+func (p *Printer) markTerms(line string, kinds []int) {
+    runes := []rune(strings.ToLower(line))
+    for _, term := range p.Terms {
+        t := []rune(strings.ToLower(term))
+        if len(t) == 0 {
+            continue
+        }
+        for i := 0; i+len(t) <= len(runes); i++ {
+            if string(runes[i:i+len(t)]) == string(t) {
+                for j := i; j < i+len(t); j++ {
+                    kinds[j] = tokMatch
+                }
+                i += len(t) - 1
+            }
+        }
+    }
+}
+
+// render writes line with an ANSI style for each run of runes of the same
+// kind.
+func (p *Printer) render(line string, kinds []int) string {
+    runes := []rune(line)
+    var sb strings.Builder
+    for i := 0; i < len(runes); {
+        j := i
+        for j < len(runes) && kinds[j] == kinds[i] {
+            j++
+        }
+        style := p.Theme.style(kinds[i])
+        if style == "" {
+            sb.WriteString(string(runes[i:j]))
+        } else {
+            sb.WriteString(style + string(runes[i:j]) + ansiReset)
+        }
+        i = j
+    }
+    return sb.String()
+}
+
+// syntax describes what a highlighter needs to know about a language.
+type syntax struct {
+    keywords     []string
+    lineComments []string
+    blockComment [2]string
+    quotes       string
+}
+
+var cKeywords = []string{"auto", "break", "case", "char", "const", "continue", "default", "do", "double",
+    "else", "enum", "extern", "float", "for", "goto", "if", "int", "long", "return", "short", "signed",
+    "sizeof", "static", "struct", "switch", "typedef", "union", "unsigned", "void", "while", "include", "define"}
+
+var syntaxes = map[string]syntax{
+    "go": {[]string{"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough",
+        "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return",
+        "select", "struct", "switch", "type", "var", "nil", "true", "false", "string", "int", "int64",
+        "int32", "int16", "bool", "byte", "rune", "error", "float64", "make", "new", "len", "append"},
+        []string{"//"}, [2]string{"/*", "*/"}, "\"'`"},
+    "java": {[]string{"abstract", "boolean", "break", "case", "catch", "class", "continue", "default", "do",
+        "else", "extends", "final", "finally", "for", "if", "implements", "import", "instanceof", "int",
+        "interface", "long", "new", "null", "package", "private", "protected", "public", "return", "static",
+        "super", "switch", "this", "throw", "throws", "try", "void", "while", "true", "false", "String"},
+        []string{"//"}, [2]string{"/*", "*/"}, "\"'"},
+    "javascript": {[]string{"break", "case", "catch", "class", "const", "continue", "default", "delete", "do",
+        "else", "export", "for", "function", "if", "import", "in", "instanceof", "let", "new", "null", "of",
+        "return", "switch", "this", "throw", "try", "typeof", "undefined", "var", "while", "true", "false"},
+        []string{"//"}, [2]string{"/*", "*/"}, "\"'`"},
+    "c":   {cKeywords, []string{"//"}, [2]string{"/*", "*/"}, "\"'"},
+    "cpp": {append(append([]string{}, cKeywords...), "class", "namespace", "new", "delete", "public", "private", "template", "using"), []string{"//"}, [2]string{"/*", "*/"}, "\"'"},
+    "python": {[]string{"and", "as", "assert", "break", "class", "continue", "def", "del", "elif", "else",
+        "except", "finally", "for", "from", "global", "if", "import", "in", "is", "lambda", "not", "or",
+        "pass", "raise", "return", "try", "while", "with", "yield", "None", "True", "False", "print"},
+        []string{"#"}, [2]string{}, "\"'"},
+    "shell": {[]string{"if", "then", "else", "elif", "fi", "for", "in", "do", "done", "while", "until", "case",
+        "esac", "function", "return", "exit", "export", "local", "echo", "cd", "exec", "set", "source"},
+        []string{"#"}, [2]string{}, "\"'`"},
+    "sql": {[]string{"select", "from", "where", "and", "or", "not", "insert", "into", "values", "update", "set",
+        "delete", "create", "table", "database", "index", "drop", "alter", "add", "primary", "key", "unique",
+        "grant", "on", "to", "join", "left", "right", "inner", "group", "by", "order", "limit", "as", "null",
+        "default", "user", "identified", "int", "varchar", "datetime", "timestamp"},
+        []string{"--", "#"}, [2]string{"/*", "*/"}, "\"'`"},
+    "ruby": {[]string{"begin", "class", "def", "do", "else", "elsif", "end", "ensure", "if", "module", "nil",
+        "require", "rescue", "return", "self", "unless", "until", "when", "while", "yield", "true", "false"},
+        []string{"#"}, [2]string{}, "\"'"},
+    "html":     {nil, nil, [2]string{"<!--", "-->"}, "\"'"},
+    "xml":      {nil, nil, [2]string{"<!--", "-->"}, "\"'"},
+    "css":      {nil, nil, [2]string{"/*", "*/"}, "\"'"},
+    "elisp":    {nil, []string{";"}, [2]string{}, "\""},
+    "yaml":     {[]string{"true", "false", "null"}, []string{"#"}, [2]string{}, "\"'"},
+    "json":     {[]string{"true", "false", "null"}, nil, [2]string{}, "\""},
+    "markdown": {nil, nil, [2]string{}, "`"},
+}
+
+func init() {
+    syntaxes["typescript"] = syntaxes["javascript"]
+    syntaxes["rust"] = syntaxes["c"]
+}
+
+var markupTag = regexp.MustCompile(`</?[A-Za-z][\w:-]*|/?>`)
+
+// highlighter tokenizes code line by line, keeping block comment state
+// between lines.
+type highlighter struct {
+    lang           string
+    syntax         syntax
+    keywords       map[string]bool
+    inBlockComment bool
+}
+
+func newHighlighter(lang string) *highlighter {
+    s := syntaxes[lang]
+    keywords := map[string]bool{}
+    for _, k := range s.keywords {
+        keywords[k] = true
+        if lang == "sql" {
+            keywords[strings.ToUpper(k)] = true
+        }
+    }
+    return &highlighter{lang, s, keywords, false}
+}
+
+// tokenize returns the token kind of every rune of line.
+func (hl *highlighter) tokenize(line string) []int {
+    runes := []rune(line)
+    kinds := make([]int, len(runes))
+    var hasPrefixAt = func(i int, prefix string) bool {
+        return prefix != "" && strings.HasPrefix(string(runes[i:]), prefix)
+    }
+    var mark = func(from, to, kind int) {
+        for k := from; k < to && k < len(kinds); k++ {
+            kinds[k] = kind
+        }
+    }
+
+    if hl.lang == "html" || hl.lang == "xml" {
+        for _, loc := range markupTag.FindAllStringIndex(line, -1) {
+            from := len([]rune(line[:loc[0]]))
+            mark(from, from+len([]rune(line[loc[0]:loc[1]])), tokKeyword)
+        }
+    }
+
+    i := 0
+    for i < len(runes) {
+        if hl.inBlockComment {
+            end := strings.Index(string(runes[i:]), hl.syntax.blockComment[1])
+            if end < 0 {
+                mark(i, len(runes), tokComment)
+                return kinds
+            }
+            to := i + len([]rune(string(runes[i:])[:end])) + len([]rune(hl.syntax.blockComment[1]))
+            mark(i, to, tokComment)
+            hl.inBlockComment = false
+            i = to
+            continue
+        }
+
+        if hasPrefixAt(i, hl.syntax.blockComment[0]) {
+            opener := len([]rune(hl.syntax.blockComment[0]))
+            mark(i, i+opener, tokComment)
+            hl.inBlockComment = true
+            i += opener
+            continue
+        }
+
+        isLineComment := false
+        for _, c := range hl.syntax.lineComments {
+            // "#" in shell is a comment only at the start of a word, eg: not in $#.
+            if hasPrefixAt(i, c) && (c != "#" || i == 0 || unicode.IsSpace(runes[i-1])) {
+                isLineComment = true
+            }
+        }
+        if isLineComment {
+            mark(i, len(runes), tokComment)
+            return kinds
+        }
+
+        r := runes[i]
+        if strings.ContainsRune(hl.syntax.quotes, r) {
+            j := i + 1
+            for j < len(runes) && runes[j] != r {
+                if runes[j] == '\\' && r != '`' {
+                    j++
+                }
+                j++
+            }
+            mark(i, j+1, tokString)
+            i = j + 1
+            continue
+        }
+
+        if unicode.IsLetter(r) || r == '_' {
+            j := i
+            for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+                j++
+            }
+            if hl.keywords[string(runes[i:j])] {
+                mark(i, j, tokKeyword)
+            }
+            i = j
+            continue
+        }
+
+        if unicode.IsDigit(r) {
+            j := i
+            for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'x' ||
+                (runes[j] >= 'a' && runes[j] <= 'f') || (runes[j] >= 'A' && runes[j] <= 'F')) {
+                j++
+            }
+            mark(i, j, tokNumber)
+            i = j
+            continue
+        }
+        i++
+    }
+    return kinds
+}
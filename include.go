@@ -0,0 +1,283 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+const idAliasFileName = "idaliases.rcs"
+
+// includePattern matches the include directive {{rcs "alias-or-id"}}.
+var includePattern = regexp.MustCompile(`\{\{rcs\s+"([^"]+)"\s*\}\}`)
+
+// IdAliases gives code segments short names to be used instead of ids,
+// stored in the codebase as lines of "name=id".
+type IdAliases struct {
+    FilePath string
+    ids      map[string]string
+}
+
+func LoadIdAliases(fpath string) (*IdAliases, error) {
+    ia := &IdAliases{fpath, map[string]string{}}
+    f, err := os.Open(fpath)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return ia, nil
+        }
+        return ia, err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        flds := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+        if len(flds) == 2 {
+            ia.ids[flds[0]] = flds[1]
+        }
+    }
+    return ia, scanner.Err()
+}
+
+func (ia *IdAliases) save() error {
+    f, err := os.OpenFile(ia.FilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    for _, name := range ia.Names() {
+        if _, err = f.WriteString(name + "=" + ia.ids[name] + "\n"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (ia *IdAliases) Names() []string {
+    names := []string{}
+    for name := range ia.ids {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Resolve returns the id named by ref, or ref itself if it is not a name.
+func (ia *IdAliases) Resolve(ref string) string {
+    if id, ok := ia.ids[ref]; ok {
+        return id
+    }
+    return ref
+}
+
+// NamesOf returns the names given to id.
+func (ia *IdAliases) NamesOf(id string) []string {
+    names := []string{}
+    for _, name := range ia.Names() {
+        if ia.ids[name] == id {
+            names = append(names, name)
+        }
+    }
+    return names
+}
+
+// AliasSegment gives segment id the short name name.
+func (op *Operator) AliasSegment(name string, id string) {
+    if op.err != nil {
+        return
+    }
+    if name == "" || strings.ContainsAny(name, "=\" \t\n") {
+        op.err = errors.New("invalid name: " + name)
+        return
+    }
+    cs, err := op.store.GetById(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.idAliases.ids[name] = cs.Id
+    op.err = op.idAliases.save()
+}
+
+func (op *Operator) UnaliasSegment(name string) {
+    if _, ok := op.idAliases.ids[name]; !ok {
+        op.err = errors.New("name not found: " + name)
+        return
+    }
+    delete(op.idAliases.ids, name)
+    op.err = op.idAliases.save()
+}
+
+func (op *Operator) ListSegmentAliases() {
+    for _, name := range op.idAliases.Names() {
+        fmt.Printf("%-24s%s\n", name, op.idAliases.ids[name])
+    }
+}
+
+// getByRef returns the segment named by an alias or id.
+func (op *Operator) getByRef(ref string) (CodeSegment, error) {
+    if op.idAliases != nil {
+        ref = op.idAliases.Resolve(ref)
+    }
+    return op.store.GetById(ref)
+}
+
+// includeRefs returns the references of the include directives in code.
+func includeRefs(code string) []string {
+    refs := []string{}
+    for _, m := range includePattern.FindAllStringSubmatch(code, -1) {
+        if !ArrContains(refs, m[1]) {
+            refs = append(refs, m[1])
+        }
+    }
+    return refs
+}
+
+// ExpandIncludes replaces the include directives in the code of cs with the
+// code of the included segments, recursively. A directive alone on its line
+// gets the included code indented like the directive.
+func (op *Operator) ExpandIncludes(cs CodeSegment) (string, error) {
+    return op.expand(cs, []string{cs.Id})
+}
+
+func (op *Operator) expand(cs CodeSegment, stack []string) (string, error) {
+    var include = func(ref string) (string, error) {
+        included, err := op.getByRef(ref)
+        if err != nil {
+            return "", errors.New("include " + ref + " in " + cs.Id + ": " + err.Error())
+        }
+        if ArrContains(stack, included.Id) {
+            return "", errors.New("include cycle: " + strings.Join(append(stack, included.Id), " -> "))
+        }
+        return op.expand(included, append(stack, included.Id))
+    }
+
+    lines := strings.Split(cs.Code, "\n")
+    for i, line := range lines {
+        matches := includePattern.FindAllStringSubmatch(line, -1)
+        if len(matches) == 0 {
+            continue
+        }
+
+        if len(matches) == 1 && strings.TrimSpace(line) == matches[0][0] {
+            code, err := include(matches[0][1])
+            if err != nil {
+                return "", err
+            }
+            lines[i] = strings.Join(indentLines(code, leadingSpace(line)), "\n")
+            continue
+        }
+
+        var includeErr error
+        lines[i] = includePattern.ReplaceAllStringFunc(line, func(directive string) string {
+            code, err := include(includePattern.FindStringSubmatch(directive)[1])
+            if err != nil && includeErr == nil {
+                includeErr = err
+            }
+            return code
+        })
+        if includeErr != nil {
+            return "", includeErr
+        }
+    }
+    return strings.Join(lines, "\n"), nil
+}
+
+// Deps prints the segments included by segment ref, recursively.
+func (op *Operator) Deps(ref string) {
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    seen := map[string]bool{cs.Id: true}
+    var walk func(cs CodeSegment, depth int)
+    walk = func(cs CodeSegment, depth int) {
+        for _, r := range includeRefs(cs.Code) {
+            included, err := op.getByRef(r)
+            if err != nil {
+                fmt.Printf("%s%s (missing)\n", strings.Repeat("    ", depth), r)
+                continue
+            }
+            fmt.Printf("%s%s %s|%s\n", strings.Repeat("    ", depth), included.Id, included.Category, included.Tags)
+            if !seen[included.Id] {
+                seen[included.Id] = true
+                walk(included, depth+1)
+            }
+        }
+    }
+    walk(cs, 0)
+}
+
+// Dependents returns the segments that include segment id directly, by id
+// or by one of its names.
+func (op *Operator) Dependents(id string) []CodeSegment {
+    refs := []string{id}
+    if op.idAliases != nil {
+        refs = append(refs, op.idAliases.NamesOf(id)...)
+    }
+
+    res := []CodeSegment{}
+    for _, cs := range op.store.Search("", "") {
+        for _, r := range includeRefs(cs.Code) {
+            if ArrContains(refs, r) {
+                res = append(res, cs)
+                break
+            }
+        }
+    }
+    return res
+}
+
+// warnDependents prints the segments including segment id, they can no
+// longer be expanded once it is removed.
+func (op *Operator) warnDependents(id string) {
+    dependents := op.Dependents(id)
+    if len(dependents) == 0 {
+        return
+    }
+    fmt.Println("Warning: code segment " + id + " is included by:")
+    for _, cs := range dependents {
+        fmt.Println("    " + cs.Id + " " + cs.Category + "|" + cs.Tags)
+    }
+}
+
+// ReverseDeps prints the segments that include segment ref.
+func (op *Operator) ReverseDeps(ref string) {
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+    for _, dep := range op.Dependents(cs.Id) {
+        fmt.Printf("%s %s|%s\n", dep.Id, dep.Category, dep.Tags)
+    }
+}
+
+// Cat prints the code of segment ref, with includes expanded unless
+// noExpand is set.
+func (op *Operator) Cat(ref string, noExpand bool) {
+    if op.err != nil {
+        return
+    }
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    code := cs.Code
+    if !noExpand {
+        if code, op.err = op.ExpandIncludes(cs); op.err != nil {
+            return
+        }
+    }
+    fmt.Println(code)
+    op.recordUse(cs.Id)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// diffContext is the number of context lines around a change in a diff.
+const diffContext = 3
+
+// leadingSpace returns the leading whitespace of line.
+func leadingSpace(line string) string {
+    return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// indentLines prefixes every non blank line of code with indent.
+func indentLines(code string, indent string) []string {
+    lines := strings.Split(code, "\n")
+    for i, line := range lines {
+        if strings.TrimSpace(line) != "" {
+            lines[i] = indent + line
+        }
+    }
+    return lines
+}
+
+// insertionDiff returns a unified diff of inserting inserted before index at
+// of lines.
+func insertionDiff(fpath string, lines []string, at int, inserted []string) string {
+    from := at - diffContext
+    if from < 0 {
+        from = 0
+    }
+    to := at + diffContext
+    if to > len(lines) {
+        to = len(lines)
+    }
+
+    oldCount := to - from
+    oldStart := from + 1
+    if oldCount == 0 {
+        oldStart = from
+    }
+
+    var sb strings.Builder
+    sb.WriteString("--- " + fpath + "\n")
+    sb.WriteString("+++ " + fpath + "\n")
+    sb.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, from+1, oldCount+len(inserted)))
+    for _, line := range lines[from:at] {
+        sb.WriteString(" " + line + "\n")
+    }
+    for _, line := range inserted {
+        sb.WriteString("+" + line + "\n")
+    }
+    for _, line := range lines[at:to] {
+        sb.WriteString(" " + line + "\n")
+    }
+    return sb.String()
+}
+
+// Insert writes the code of segment id (or its name) into file target, before line
+// atLine (1 based) or after the first line containing marker, indented like
+// the target location. Includes are expanded and placeholders filled with values. The
+// original file is kept as target + ".bak". With dryRun only the diff is
+// printed.
+func (op *Operator) Insert(id string, target string, atLine int, marker string, values map[string]string, dryRun bool) {
+    if op.err != nil {
+        return
+    }
+    if (atLine > 0) == (marker != "") {
+        op.err = errors.New("one of --at-line and --after-marker is required.")
+        return
+    }
+
+    cs, err := op.getByRef(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+    code, err := op.ExpandIncludes(cs)
+    if err != nil {
+        op.err = err
+        return
+    }
+    code, err = RenderTemplate(code, values)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    fi, err := os.Stat(target)
+    if err != nil {
+        op.err = err
+        return
+    }
+    content, err := ioutil.ReadFile(target)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    text := string(content)
+    endsWithNewline := strings.HasSuffix(text, "\n")
+    lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+    if text == "" {
+        lines = []string{}
+    }
+
+    var at int
+    var indent string
+    if marker != "" {
+        at = -1
+        for i, line := range lines {
+            if strings.Contains(line, marker) {
+                at = i + 1
+                indent = leadingSpace(line)
+                break
+            }
+        }
+        if at < 0 {
+            op.err = errors.New("marker not found in " + target + ": " + marker)
+            return
+        }
+    } else {
+        if atLine > len(lines)+1 {
+            op.err = errors.New(target + " has only " + strconv.Itoa(len(lines)) + " lines.")
+            return
+        }
+        at = atLine - 1
+        // take the indent of the line pushed down, or of the line above if
+        // that one is blank or the end of file.
+        if at < len(lines) && strings.TrimSpace(lines[at]) != "" {
+            indent = leadingSpace(lines[at])
+        } else if at > 0 {
+            indent = leadingSpace(lines[at-1])
+        }
+    }
+
+    inserted := indentLines(code, indent)
+    if dryRun {
+        fmt.Print(insertionDiff(target, lines, at, inserted))
+        return
+    }
+
+    if err = ioutil.WriteFile(target+".bak", content, fi.Mode()); err != nil {
+        op.err = err
+        return
+    }
+
+    newLines := append(append(append([]string{}, lines[:at]...), inserted...), lines[at:]...)
+    newText := strings.Join(newLines, "\n")
+    if endsWithNewline || text == "" {
+        newText += "\n"
+    }
+    op.err = ioutil.WriteFile(target, []byte(newText), fi.Mode())
+    if op.err == nil {
+        op.recordUse(cs.Id)
+        fmt.Printf("inserted %d lines into %s at line %d, backup in %s\n", len(inserted), target, at+1, target+".bak")
+    }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// extLanguages maps file extensions to language names.
+var extLanguages = map[string]string{
+    ".go":         "go",
+    ".sh":         "shell",
+    ".bash":       "shell",
+    ".zsh":        "shell",
+    ".py":         "python",
+    ".js":         "javascript",
+    ".ts":         "typescript",
+    ".java":       "java",
+    ".c":          "c",
+    ".h":          "c",
+    ".cc":         "cpp",
+    ".cpp":        "cpp",
+    ".hpp":        "cpp",
+    ".rb":         "ruby",
+    ".rs":         "rust",
+    ".sql":        "sql",
+    ".html":       "html",
+    ".htm":        "html",
+    ".css":        "css",
+    ".xml":        "xml",
+    ".json":       "json",
+    ".yml":        "yaml",
+    ".yaml":       "yaml",
+    ".md":         "markdown",
+    ".el":         "elisp",
+    ".properties": "properties",
+    ".txt":        "text",
+}
+
+// languageAliases maps common short names to language names.
+var languageAliases = map[string]string{
+    "golang": "go",
+    "sh":     "shell",
+    "bash":   "shell",
+    "zsh":    "shell",
+    "py":     "python",
+    "js":     "javascript",
+    "ts":     "typescript",
+    "c++":    "cpp",
+    "rb":     "ruby",
+    "rs":     "rust",
+    "mysql":  "sql",
+    "htm":    "html",
+    "yml":    "yaml",
+    "md":     "markdown",
+    "emacs":  "elisp",
+}
+
+// NormalizeLanguage lower-cases lang and resolves short names, eg: sh to
+// shell.
+func NormalizeLanguage(lang string) (string, error) {
+    lang = strings.ToLower(strings.TrimSpace(lang))
+    if l, ok := languageAliases[lang]; ok {
+        lang = l
+    }
+    for _, r := range lang {
+        if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && !strings.ContainsRune("+#-", r) {
+            return "", errors.New("invalid language: " + lang)
+        }
+    }
+    return lang, nil
+}
+
+// LanguageOfFile detects language by file extension, or by content if the
+// extension is unknown.
+func LanguageOfFile(fpath string, content string) string {
+    if lang, ok := extLanguages[strings.ToLower(filepath.Ext(fpath))]; ok {
+        return lang
+    }
+    return DetectLanguage(content)
+}
+
+type languageRule struct {
+    lang    string
+    pattern *regexp.Regexp
+}
+
+// languageRules are checked in order, the first match wins.
+var languageRules = []languageRule{
+    {"shell", regexp.MustCompile(`\A#!\S*/(env\s+)?(ba|z)?sh\b`)},
+    {"python", regexp.MustCompile(`\A#!\S*/(env\s+)?python`)},
+    {"markdown", regexp.MustCompile(`\A#{1,6} \S.*\n(.*\n)*(#{1,6} \S|\s*([*-]|\d+\.) \S)`)},
+    {"xml", regexp.MustCompile(`\A<\?xml|<project\s[^>]*xmlns`)},
+    {"html", regexp.MustCompile(`(?i)<!DOCTYPE html|<html[\s>]|(?m)^\s*<(div|span|body|head|script|a|p|li|ul|table|form)[\s>]`)},
+    {"go", regexp.MustCompile(`(?m)^package \w+$|^func (\(\w+ \*?\w+\) )?\w+\(|^type \w+ (struct|interface) \{|:= |\bif err != nil\b|` +
+        `\b(fmt|strings|strconv|json|http|os|ioutil|bufio|errors|time)\.[A-Z]\w*\(|` + "`(json|xml):\"")},
+    {"java", regexp.MustCompile(`(?m)^import java\.|\bpublic (static )?(class|void)\b|System\.out\.print`)},
+    {"sql", regexp.MustCompile(`(?i)^\s*(select\s.+\sfrom|insert\s+into|update\s+\w+\s+set|delete\s+from|create\s+(table|database|user|index)|alter\s+table|drop\s+(table|database)|grant\s)`)},
+    {"python", regexp.MustCompile(`(?m)^\s*(def \w+\(.*\):|from [\w.]+ import |import \w+$|print\()`)},
+    {"javascript", regexp.MustCompile(`\bfunction\s*\w*\(|\bconsole\.\w+\(|\$\.(ajax|get|post)\(|\$\(['"]|\b(var|let|const) \w+ = |\bJSON\.(parse|stringify)\(`)},
+    {"css", regexp.MustCompile(`(?m)^[.#]?[\w-]+\s*\{\s*$`)},
+    {"shell", regexp.MustCompile(`(?m)^\s*(echo|export|sudo|cd|ls|grep|find|apt-get|yum|brew|wget|curl|tar|zip|unzip|git|docker|mvn|ssh|chmod|for \w+ in) |\$\{\w+\}|\|\s*(grep|awk|sed|xargs)\b`)},
+}
+
+// DetectLanguage guesses the language of code by content heuristics. It
+// returns an empty string if nothing matches.
+func DetectLanguage(code string) string {
+    code = strings.TrimSpace(code)
+    if code == "" {
+        return ""
+    }
+
+    if (strings.HasPrefix(code, "{") || strings.HasPrefix(code, "[")) && json.Valid([]byte(code)) {
+        return "json"
+    }
+
+    for _, rule := range languageRules {
+        if rule.pattern.MatchString(code) {
+            return rule.lang
+        }
+    }
+    return ""
+}
+
+// EffectiveLanguage returns the language of cs, detected from its content if
+// it was saved without one.
+func (cs CodeSegment) EffectiveLanguage() string {
+    if cs.Language != "" {
+        return cs.Language
+    }
+    return DetectLanguage(cs.Code)
+}
+
+// filterByLanguage keeps the segments whose language is lang.
+func filterByLanguage(segs []CodeSegment, lang string) []CodeSegment {
+    if lang == "" {
+        return segs
+    }
+    res := []CodeSegment{}
+    for _, cs := range segs {
+        if cs.EffectiveLanguage() == lang {
+            res = append(res, cs)
+        }
+    }
+    return res
+}
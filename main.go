@@ -2,9 +2,13 @@ package main
 
 import (
     "fmt"
+    "io/ioutil"
     "os"
     "os/user"
+    "path/filepath"
+    "strconv"
     "strings"
+    "time"
 )
 
 var defaultCodeBase = ".rcs/data/"
@@ -29,18 +33,64 @@ func init() {
     }
 }
 
-// keep things simple: category should be one world only. tags can have multiple world, seperated by comma(,).
+// keep things simple: category should be one world only, or words joined by '/' for sub categories. tags can have multiple world, seperated by comma(,).
 func main() {
     if len(os.Args) <= 1 {
         printUsage(os.Args)
         os.Exit(-1)
     }
 
-    op := newOperator(&FileStore{segFilePath})
+    aliases, err := LoadTagAliases(defaultCodeBase + aliasFileName)
+    if err != nil {
+        fmt.Println("error:", err)
+        os.Exit(-1)
+    }
+
+    // only the paged commands take --no-pager, other commands keep it as
+    // content or arguments.
+    if ArrContains(pagedCommands, os.Args[1]) {
+        args, noPager := removeFlag(os.Args, "--no-pager")
+        os.Args = args
+        if !noPager {
+            stopPager = startPager()
+        }
+    }
+
+    idAliases, err := LoadIdAliases(defaultCodeBase + idAliasFileName)
+    if err != nil {
+        fmt.Println("error:", err)
+        exit(-1)
+    }
+
+    usage, err := LoadUsage(defaultCodeBase + usageFileName)
+    if err != nil {
+        fmt.Println("error:", err)
+        exit(-1)
+    }
+
+    collections, err := LoadCollections(defaultCodeBase + collectionFileName)
+    if err != nil {
+        fmt.Println("error:", err)
+        exit(-1)
+    }
+
+    op := newOperator(&FileStore{segFilePath}, aliases, idAliases, usage, collections)
+    // go code is only checked when asked for, --gofmt implies --check.
+    if ArrContains([]string{"add", "edit", "import"}, os.Args[1]) {
+        args, check := removeFlag(os.Args, "--check")
+        args, gofmt := removeFlag(args, "--gofmt")
+        os.Args = args
+        op.goCheck = GoCheck{check || gofmt, gofmt}
+    }
     switch os.Args[1] {
     case "add":
-        codeSeg := parseArgs(os.Args)
-        op.Add(codeSeg)
+        args, suggest := removeFlag(os.Args, "--suggest-tags")
+        codeSeg := parseArgs(args)
+        if suggest {
+            op.SuggestTags(codeSeg)
+        } else {
+            op.Add(codeSeg)
+        }
     case "update":
         codeSeg := parseArgs(os.Args)
         op.Update(codeSeg)
@@ -51,33 +101,161 @@ func main() {
         ids := os.Args[2:]
         op.Merge(ids...)
     case "list-c":
-        op.ListCates()
+        if len(os.Args) > 2 && os.Args[2] == "--tree" {
+            op.ListCateTree()
+        } else {
+            op.ListCates()
+        }
     case "list-t":
         op.ListTags()
     case "search":
-        codeSeg := parseArgs(os.Args)
-        op.Search(codeSeg.Category, codeSeg.Tags)
+        args, related := removeFlag(os.Args, "--related")
+        codeSeg := parseArgs(args)
+        op.Search(codeSeg.Category, codeSeg.Tags, codeSeg.Language, codeSeg.Code, related)
+    case "similar":
+        runSimilarCmd(op, os.Args)
+    case "pin":
+        if len(os.Args) < 3 {
+            printUsage(os.Args)
+            exit(-1)
+        }
+        op.CollectionAdd(PinnedCollection, os.Args[2:]...)
+    case "unpin":
+        if len(os.Args) < 3 {
+            printUsage(os.Args)
+            exit(-1)
+        }
+        op.CollectionRemove(PinnedCollection, os.Args[2:]...)
+    case "pins":
+        if len(collections.ids[PinnedCollection]) == 0 {
+            fmt.Println("no pinned code segment.")
+        } else {
+            op.ShowCollection(PinnedCollection)
+        }
+    case "collection":
+        runCollectionCmd(op, os.Args)
+    case "export":
+        runExportCmd(op, os.Args)
+    case "import":
+        args, yes := removeFlag(os.Args, "--yes")
+        args, format := removeParam(args, "--format")
+        if len(args) != 3 || (format != "" && format != "markdown") {
+            printUsage(args)
+            exit(-1)
+        }
+        op.ImportMarkdown(args[2], yes)
+    case "top":
+        op.Top(parseNum(os.Args, 10))
+    case "recent":
+        op.Recent(parseNum(os.Args, 10))
+    case "stale":
+        if len(os.Args) != 4 || os.Args[2] != "--unused-for" {
+            printUsage(os.Args)
+            exit(-1)
+        }
+        unusedFor, err := parseAge(os.Args[3])
+        if err != nil {
+            fmt.Println("error:", err)
+            exit(-1)
+        }
+        op.Stale(unusedFor)
     case "remove":
         id := os.Args[2]
+        op.warnDependents(id)
         fmt.Println("Are you sure to remove code segment with id("+id+")?", "  yes|no")
         var response string
         _, err := fmt.Scanln(&response)
         if err != nil {
             fmt.Println(err)
-            os.Exit(-1)
+            exit(-1)
         }
 
         if "YES" == strings.ToUpper(response) {
             op.Remove(id)
         }
     case "edit":
-        id := os.Args[2]
-        op.Edit(id)
+        if len(os.Args) < 3 {
+            printUsage(os.Args)
+            exit(-1)
+        }
+        if os.Args[2] == "--query" {
+            if len(os.Args) < 4 {
+                fmt.Println("missing parameter value for ", "--query")
+                exit(-1)
+            }
+            cate, tagStr, lang := parseQuery(strings.Join(os.Args[3:], " "))
+            op.BulkEdit(filterByLanguage(op.store.Search(cate, tagStr), lang))
+        } else if len(os.Args) == 3 {
+            op.Edit(os.Args[2])
+        } else {
+            op.BulkEdit(op.getByIds(os.Args[2:]))
+        }
+    case "cat":
+        args, noExpand := removeFlag(os.Args, "--no-expand")
+        if len(args) == 5 && args[3] == "--file" {
+            op.CatFile(args[2], args[4], noExpand)
+        } else if len(args) == 3 {
+            op.Cat(args[2], noExpand)
+        } else {
+            printUsage(args)
+            exit(-1)
+        }
+    case "materialize":
+        args, force := removeFlag(os.Args, "--force")
+        if len(args) != 4 {
+            printUsage(args)
+            exit(-1)
+        }
+        op.Materialize(args[2], args[3], force)
+    case "run":
+        runRunCmd(op, os.Args)
+    case "test":
+        runTestCmd(op, os.Args)
+    case "dupes":
+        op.Dupes()
+    case "deps":
+        args, reverse := removeFlag(os.Args, "--reverse")
+        if len(args) != 3 {
+            printUsage(args)
+            exit(-1)
+        }
+        if reverse {
+            op.ReverseDeps(args[2])
+        } else {
+            op.Deps(args[2])
+        }
+    case "alias":
+        if len(os.Args) == 2 {
+            op.ListSegmentAliases()
+        } else if len(os.Args) == 4 {
+            op.AliasSegment(os.Args[2], os.Args[3])
+        } else {
+            printUsage(os.Args)
+        }
+    case "unalias":
+        if len(os.Args) != 3 {
+            printUsage(os.Args)
+            exit(-1)
+        }
+        op.UnaliasSegment(os.Args[2])
+    case "render":
+        runRenderCmd(op, os.Args)
+    case "insert":
+        runInsertCmd(op, os.Args)
+    case "browse":
+        op.Browse(false)
+    case "pick":
+        op.Browse(true)
+    case "tag":
+        runTagCmd(op, os.Args)
+    case "category":
+        runCategoryCmd(op, os.Args)
     case "help":
         printUsage(os.Args)
     default:
         printUsage(os.Args)
     }
+    stopPager()
 
     if op.err != nil {
         fmt.Println("error:", op.err)
@@ -107,32 +285,427 @@ func parseArgs(args []string) CodeSegment {
         return ""
     }
 
+    // getParams returns the values of a flag that can be repeated.
+    var getParams = func(flag string) []string {
+        values := []string{}
+        for i, a := range args {
+            if a == flag && i+1 < len(args) {
+                values = append(values, args[i+1])
+                argsLen += 2
+            }
+        }
+        return values
+    }
+
     id := getParam("-i")
     cate := getParam("-c")
     tagStr := getParam("-t")
     desc := getParam("-m")
+    lang := getParam("--lang")
+    grep := getParam("--grep")
+    fpaths := getParams("-f")
+    fromGo := getParam("--from-go")
+    test := getParam("--test")
+    expect := getParam("--expect")
     var content string
     if len(args) > argsLen {
         content = strings.Join(args[argsLen:], " ")
     }
 
+    var files []CodeFile
+    for _, fpath := range fpaths {
+        bs, err := ioutil.ReadFile(fpath)
+        if err != nil {
+            fmt.Println("error:", err)
+            exit(-1)
+        }
+        files = append(files, CodeFile{filepath.Base(fpath), string(bs)})
+    }
+    if len(files) > 0 {
+        content = files[0].Content
+        if lang == "" {
+            lang = LanguageOfFile(fpaths[0], content)
+        }
+    }
+    if fromGo != "" {
+        i := strings.LastIndex(fromGo, ":")
+        if i <= 0 {
+            fmt.Println("error: --from-go should be file.go:Symbol")
+            exit(-1)
+        }
+        code, doc, err := ExtractGoSymbol(fromGo[:i], fromGo[i+1:])
+        if err != nil {
+            fmt.Println("error:", err)
+            exit(-1)
+        }
+        content = code
+        lang = "go"
+        if desc == "" {
+            desc = strings.TrimSpace(doc)
+        }
+    }
+
+    // a single file is stored as a plain segment.
+    if len(files) == 1 {
+        files = nil
+    }
+
     if args[1] == "search" {
         tagStr = strings.Join(args[argsLen:], ",")
+        content = grep
     }
 
-    return CodeSegment{id, cate, tagStr, desc, content}
+    return CodeSegment{id, cate, tagStr, desc, content, lang, files, test, expect}
+}
+
+// removeFlag removes flag from args and reports whether it was present.
+func removeFlag(args []string, flag string) ([]string, bool) {
+    rest := []string{}
+    found := false
+    for _, a := range args {
+        if a == flag {
+            found = true
+            continue
+        }
+        rest = append(rest, a)
+    }
+    return rest, found
+}
+
+// runRunCmd handles: rcs run id [--keep] [--timeout 10s] [args] [-- args]
+func runRunCmd(op *Operator, args []string) {
+    rest := []string{}
+    for i, a := range args {
+        if a == "--" {
+            rest = args[i+1:]
+            args = args[:i]
+            break
+        }
+    }
+    args, keep := removeFlag(args, "--keep")
+    args, timeout := removeTimeout(args)
+    if len(args) < 3 {
+        printUsage(args)
+        exit(-1)
+    }
+    op.Run(args[2], append(args[3:], rest...), timeout, keep)
+}
+
+// runSimilarCmd handles: rcs similar id|name [-n 5]
+func runSimilarCmd(op *Operator, args []string) {
+    n := parseNum(args, 5)
+    if len(args) == 5 && args[3] == "-n" {
+        args = args[:3]
+    }
+    if len(args) != 3 {
+        printUsage(args)
+        exit(-1)
+    }
+    op.Similar(args[2], n)
+}
+
+// runCollectionCmd handles:
+//   rcs collection list
+//   rcs collection add name id [id2 ...]
+//   rcs collection remove name [id ...]
+//   rcs collection show name
+//   rcs collection export name [file]
+func runCollectionCmd(op *Operator, args []string) {
+    if len(args) == 3 && args[2] == "list" {
+        op.ListCollections()
+    } else if len(args) >= 5 && args[2] == "add" {
+        op.CollectionAdd(args[3], args[4:]...)
+    } else if len(args) >= 4 && args[2] == "remove" {
+        op.CollectionRemove(args[3], args[4:]...)
+    } else if len(args) == 4 && args[2] == "show" {
+        op.ShowCollection(args[3])
+    } else if (len(args) == 4 || len(args) == 5) && args[2] == "export" {
+        fpath := ""
+        if len(args) == 5 {
+            fpath = args[4]
+        }
+        op.ExportCollection(args[3], fpath)
+    } else {
+        printUsage(args)
+        exit(-1)
+    }
+}
+
+// removeParam removes "flag value" from args and returns the value, empty
+// if not given.
+func removeParam(args []string, flag string) ([]string, string) {
+    for i := 2; i < len(args)-1; i++ {
+        if args[i] == flag {
+            return append(args[:i:i], args[i+2:]...), args[i+1]
+        }
+    }
+    return args, ""
+}
+
+// runExportCmd handles:
+//   rcs export --format markdown [--query "cate:go"|--collection name] [-o file|--split dir]
+func runExportCmd(op *Operator, args []string) {
+    args, format := removeParam(args, "--format")
+    args, query := removeParam(args, "--query")
+    args, collection := removeParam(args, "--collection")
+    args, fpath := removeParam(args, "-o")
+    args, splitDir := removeParam(args, "--split")
+    if len(args) != 2 || (format != "" && format != "markdown") || (query != "" && collection != "") {
+        printUsage(args)
+        exit(-1)
+    }
+
+    var segs []CodeSegment
+    if collection != "" {
+        var err error
+        if segs, err = op.collectionSegments(collection); err != nil {
+            op.err = err
+            return
+        }
+    } else {
+        cate, tagStr, lang := parseQuery(query)
+        segs = filterByLanguage(op.store.Search(cate, tagStr), lang)
+    }
+    op.ExportMarkdown(segs, fpath, splitDir)
+}
+
+// parseNum returns the value of the trailing "-n number" of args, or def.
+func parseNum(args []string, def int) int {
+    if len(args) < 2 || args[len(args)-2] != "-n" {
+        return def
+    }
+    n, err := strconv.Atoi(args[len(args)-1])
+    if err != nil || n <= 0 {
+        fmt.Println("error: invalid number:", args[len(args)-1])
+        exit(-1)
+    }
+    return n
+}
+
+// removeTimeout removes "--timeout duration" from args and returns the
+// duration, DefaultRunTimeout if not given.
+func removeTimeout(args []string) ([]string, time.Duration) {
+    for i := 2; i < len(args)-1; i++ {
+        if args[i] == "--timeout" {
+            d, err := time.ParseDuration(args[i+1])
+            if err != nil {
+                fmt.Println("error: invalid timeout:", args[i+1])
+                exit(-1)
+            }
+            return append(args[:i:i], args[i+2:]...), d
+        }
+    }
+    return args, DefaultRunTimeout
+}
+
+// runTestCmd handles: rcs test [id ...] [--query "cate:go tag:json"] [--timeout 10s]
+func runTestCmd(op *Operator, args []string) {
+    args, timeout := removeTimeout(args)
+    if len(args) > 2 && args[2] == "--query" {
+        if len(args) < 4 {
+            fmt.Println("missing parameter value for ", "--query")
+            exit(-1)
+        }
+        cate, tagStr, lang := parseQuery(strings.Join(args[3:], " "))
+        op.Test(filterByLanguage(op.store.Search(cate, tagStr), lang), timeout)
+    } else if len(args) > 2 {
+        op.Test(op.getByIds(args[2:]), timeout)
+    } else {
+        op.Test(op.store.Search("", ""), timeout)
+    }
+}
+
+func runTagCmd(op *Operator, args []string) {
+    args, dryRun := removeFlag(args, "--dry-run")
+    if len(args) == 3 && args[2] == "alias" {
+        op.ListAliases()
+        return
+    }
+    if len(args) == 3 && args[2] == "suggest" {
+        op.SuggestAliases()
+        return
+    }
+    if len(args) < 4 {
+        printUsage(args)
+        exit(-1)
+    }
+
+    switch args[2] {
+    case "alias":
+        if len(args) != 5 {
+            printUsage(args)
+            exit(-1)
+        }
+        op.AliasTag(args[3], args[4])
+    case "unalias":
+        op.UnaliasTag(args[3])
+    case "rename":
+        if len(args) != 5 {
+            printUsage(args)
+            exit(-1)
+        }
+        op.RenameTag(args[3], args[4], dryRun)
+    case "merge":
+        params := args[3:]
+        if len(params) < 3 || params[len(params)-2] != "--into" {
+            printUsage(args)
+            exit(-1)
+        }
+        op.MergeTags(params[:len(params)-2], params[len(params)-1], dryRun)
+    case "delete":
+        op.DeleteTag(args[3], dryRun)
+    default:
+        printUsage(args)
+    }
+}
+
+func runRenderCmd(op *Operator, args []string) {
+    args, list := removeFlag(args, "--list")
+    args, interactive := removeFlag(args, "--interactive")
+    args, noExpand := removeFlag(args, "--no-expand")
+    if len(args) < 3 {
+        printUsage(args)
+        exit(-1)
+    }
+
+    if list {
+        op.ListPlaceholders(args[2])
+        return
+    }
+
+    values, err := parseVars(args[3:])
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.Render(args[2], values, interactive, noExpand)
+}
+
+func runInsertCmd(op *Operator, args []string) {
+    args, dryRun := removeFlag(args, "--dry-run")
+    if len(args) < 3 {
+        printUsage(args)
+        exit(-1)
+    }
+
+    var target, marker string
+    var atLine int
+    vars := []string{}
+    for i := 3; i < len(args); i++ {
+        switch args[i] {
+        case "--into", "--at-line", "--after-marker":
+            if i+1 >= len(args) {
+                fmt.Println("missing parameter value for ", args[i])
+                exit(-1)
+            }
+            value := args[i+1]
+            i++
+            switch args[i-1] {
+            case "--into":
+                target = value
+            case "--after-marker":
+                marker = value
+            case "--at-line":
+                n, err := strconv.Atoi(value)
+                if err != nil || n <= 0 {
+                    fmt.Println("invalid line number:", value)
+                    exit(-1)
+                }
+                atLine = n
+            }
+        default:
+            vars = append(vars, args[i])
+        }
+    }
+
+    if target == "" {
+        fmt.Println("missing parameter value for ", "--into")
+        exit(-1)
+    }
+
+    values, err := parseVars(vars)
+    if err != nil {
+        op.err = err
+        return
+    }
+    op.Insert(args[2], target, atLine, marker, values, dryRun)
+}
+
+func runCategoryCmd(op *Operator, args []string) {
+    args, dryRun := removeFlag(args, "--dry-run")
+    if len(args) == 3 && args[2] == "migrate" {
+        op.MigrateCategories(dryRun)
+        return
+    }
+    if len(args) != 5 || args[2] != "rename" {
+        printUsage(args)
+        exit(-1)
+    }
+    op.RenameCategory(args[3], args[4], dryRun)
+}
+
+// parseQuery parses a query like "cate:go tag:json tag:encode lang:go".
+// Words without a prefix are taken as tags.
+func parseQuery(query string) (cate string, tagStr string, lang string) {
+    tags := []string{}
+    for _, word := range strings.Fields(query) {
+        if strings.HasPrefix(word, "cate:") {
+            cate = word[len("cate:"):]
+        } else if strings.HasPrefix(word, "lang:") {
+            lang, _ = NormalizeLanguage(word[len("lang:"):])
+        } else if strings.HasPrefix(word, "tag:") {
+            tags = append(tags, word[len("tag:"):])
+        } else {
+            tags = append(tags, word)
+        }
+    }
+    tagStr = strings.Join(tags, ",")
+    return
 }
 
 func printUsage(args []string) {
-    fmt.Printf("Usage:\n    %s add|update|search|remove|list-c|list-t|merge|append|edit|help\n", args[0])
-    fmt.Printf("\tadd -t tag1,tag2 -c category -m description content\n")
-    fmt.Printf("\tsearch [-c category] tag1 tag2\n")
+    fmt.Printf("Usage:\n    %s add|update|search|remove|list-c|list-t|merge|append|edit|cat|materialize|run|test|dupes|similar|top|recent|stale|pin|unpin|pins|collection|export|import|deps|alias|unalias|render|insert|browse|pick|tag|category|help\n", args[0])
+    fmt.Printf("\tadd -t tag1,tag2 -c category -m description [--lang language] [--test command] [--expect output] content|-f file [-f file2 ...]\n")
+    fmt.Printf("\tadd --from-go file.go:Func|Type|Type.Method -c category -t tag1,tag2 [-m description]\n")
+    fmt.Printf("\t    without -t tags are suggested from the code, --suggest-tags only prints them\n")
+    fmt.Printf("\t    --check parses go code on add and edit, --gofmt also formats it without asking\n")
+    fmt.Printf("\tsearch [-c category] [--lang language] [--grep text] [--related] tag1 tag2\n")
     fmt.Printf("\tremove id\n")
-    fmt.Printf("\tupdate -i id [-t tag1,tag2 [-c category] [-m desc]] content\n")
-    fmt.Printf("\tlist-c : list all categories\n")
+    fmt.Printf("\tupdate -i id [-t tag1,tag2 [-c category] [-m desc] [--lang language]] content\n")
+    fmt.Printf("\tlist-c [--tree] : list all categories\n")
     fmt.Printf("\tlist-t : list all tags\n")
     fmt.Printf("\tmerge id1 id2 ...\n")
     fmt.Printf("\tappend -i id content\n")
-    fmt.Printf("\tedit id")
+    fmt.Printf("\tedit id [id2 ...] | edit --query \"cate:go tag:json\"\n")
+    fmt.Printf("\tcat id|name [--no-expand] : print code, with {{rcs \"id|name\"}} includes expanded\n")
+    fmt.Printf("\tcat id|name --file name : print one file of a multi-file segment\n")
+    fmt.Printf("\tmaterialize id|name dir [--force] : write the files of a segment into dir\n")
+    fmt.Printf("\trun id|name [--keep] [--timeout 30s] [args] [-- args] : run a shell, go or python segment in a scratch directory, not a sandbox: it can reach your files and network\n")
+    fmt.Printf("\tsimilar id|name [-n 5] : list the segments most similar to a segment\n")
+    fmt.Printf("\tpin id [id2 ...] | unpin id [id2 ...] | pins : pin segments and list the pinned ones\n")
+    fmt.Printf("\tcollection list | add name id [id2 ...] | remove name [id ...] | show name | export name [file]\n")
+    fmt.Printf("\texport --format markdown [--query \"cate:go\" | --collection name] [-o file | --split dir] : a document with contents, or a file per category\n")
+    fmt.Printf("\timport --format markdown notes.md [--yes] : add the code blocks of a document, headings are categories, a \"tags: a, b\" line tags\n")
+    fmt.Printf("\ttop [-n 10] | recent [-n 10] : list the most used or most recently used segments\n")
+    fmt.Printf("\tstale --unused-for 180d : list segments not catted, rendered, inserted, run or picked for a while\n")
+    fmt.Printf("\tdupes : list clusters of near-duplicate segments with a suggested merge\n")
+    fmt.Printf("\ttest [id ...] [--query \"cate:go tag:json\"] [--timeout 30s] : run the segments added with --test command and/or --expect output\n")
+    fmt.Printf("\tdeps id|name [--reverse] : list included segments, or segments including it\n")
+    fmt.Printf("\talias [name id] : give a segment a name, list names without args\n")
+    fmt.Printf("\tunalias name\n")
+    fmt.Printf("\trender id [--interactive] [--no-expand] [Name=Value ...] : fill placeholders like ${{Name}} or ${{Name:default}}\n")
+    fmt.Printf("\trender id --list : list placeholders\n")
+    fmt.Printf("\tinsert id --into file --at-line n|--after-marker marker [--dry-run] [Name=Value ...]\n")
+    fmt.Printf("\tbrowse : fuzzy find code segments in a full screen ui\n")
+    fmt.Printf("\tpick : like browse, but print only the code of the picked segment\n")
+    fmt.Printf("\ttag rename old new [--dry-run]\n")
+    fmt.Printf("\ttag merge tag1 tag2 ... --into tag [--dry-run]\n")
+    fmt.Printf("\ttag delete tag [--dry-run]\n")
+    fmt.Printf("\ttag alias [alias tag] : make alias a synonym of tag, list aliases without args\n")
+    fmt.Printf("\ttag unalias alias\n")
+    fmt.Printf("\ttag suggest : list likely synonyms among existing tags\n")
+    fmt.Printf("\tcategory rename old new [--dry-run]\n")
+    fmt.Printf("\tcategory migrate [--dry-run] : convert categories like go-strings to go/strings\n")
+    fmt.Printf("\t--no-pager : do not page the output of search, list-c, list-t, top, recent, stale, similar and dupes")
     fmt.Println()
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// markdownMarker starts a document written by rcs export, so rcs import
+// reads level 2 headings as categories and level 3 ones as segment titles.
+const markdownMarker = "<!-- rcs export -->"
+
+// testFence and expectFence are the info strings of the blocks holding the
+// test command and the expected output of the segment above them.
+const (
+    testFence   = "test"
+    expectFence = "expect"
+)
+
+var slugPunct = regexp.MustCompile(`[^\p{L}\p{N} _-]`)
+
+// anchors makes GitHub style heading anchors, numbering repeated ones.
+type anchors map[string]int
+
+func (a anchors) of(heading string) string {
+    slug := strings.Replace(slugPunct.ReplaceAllString(strings.ToLower(heading), ""), " ", "-", -1)
+    n := a[slug]
+    a[slug]++
+    if n > 0 {
+        return slug + "-" + strconv.Itoa(n)
+    }
+    return slug
+}
+
+var titleEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+
+// escapeTitle escapes a title for use as heading and link text, so brackets
+// do not end a link and a leading # does not make a deeper heading.
+func escapeTitle(title string) string {
+    title = titleEscaper.Replace(title)
+    if strings.HasPrefix(title, "#") {
+        title = `\` + title
+    }
+    return title
+}
+
+// segmentTitle is the heading of cs: the first line of its description,
+// or its tags, or its id.
+func segmentTitle(cs CodeSegment) string {
+    if title := strings.TrimSpace(firstLine(cs.Desc)); title != "" {
+        return title
+    }
+    if cs.Tags != "" {
+        return strings.Replace(cs.Tags, ",", ", ", -1)
+    }
+    return cs.Id
+}
+
+// codeFence returns a fence longer than any backtick run in code.
+func codeFence(code string) string {
+    fence := "```"
+    for strings.Contains(code, fence) {
+        fence += "`"
+    }
+    return fence
+}
+
+func writeCodeBlock(w io.Writer, code string, lang string) {
+    fence := codeFence(code)
+    io.WriteString(w, fence+lang+"\n"+code+"\n"+fence+"\n\n")
+}
+
+// groupByCategory returns the categories of segs in sorted order and the
+// segments of each.
+func groupByCategory(segs []CodeSegment) ([]string, map[string][]CodeSegment) {
+    cates := []string{}
+    byCate := map[string][]CodeSegment{}
+    for _, cs := range segs {
+        if _, ok := byCate[cs.Category]; !ok {
+            cates = append(cates, cs.Category)
+        }
+        byCate[cs.Category] = append(byCate[cs.Category], cs)
+    }
+    sort.Strings(cates)
+    return cates, byCate
+}
+
+// cateHeading is the heading of a category, segments without one are
+// listed under "uncategorized".
+func cateHeading(cate string) string {
+    if cate == "" {
+        return "uncategorized"
+    }
+    return cate
+}
+
+// WriteMarkdown writes segs as a Markdown document titled title, with a
+// table of contents, a section per category and a heading per segment.
+func WriteMarkdown(w io.Writer, title string, segs []CodeSegment) {
+    cates, byCate := groupByCategory(segs)
+
+    // anchors are worked out in document order, the contents heading first.
+    a := anchors{}
+    a.of(title)
+    a.of("Contents")
+    cateAnchors := map[string]string{}
+    segAnchors := map[string]string{}
+    for _, cate := range cates {
+        cateAnchors[cate] = a.of(cateHeading(cate))
+        for _, cs := range byCate[cate] {
+            segAnchors[cs.Id] = a.of(segmentTitle(cs))
+        }
+    }
+
+    io.WriteString(w, markdownMarker+"\n# "+title+"\n\n## Contents\n\n")
+    for _, cate := range cates {
+        fmt.Fprintf(w, "- [%s](#%s)\n", cateHeading(cate), cateAnchors[cate])
+        for _, cs := range byCate[cate] {
+            fmt.Fprintf(w, "  - [%s](#%s)\n", escapeTitle(segmentTitle(cs)), segAnchors[cs.Id])
+        }
+    }
+    io.WriteString(w, "\n")
+
+    for _, cate := range cates {
+        io.WriteString(w, "## "+cateHeading(cate)+"\n\n")
+        for _, cs := range byCate[cate] {
+            writeMarkdownSegment(w, cs)
+        }
+    }
+}
+
+func writeMarkdownSegment(w io.Writer, cs CodeSegment) {
+    io.WriteString(w, "### "+escapeTitle(segmentTitle(cs))+"\n\n")
+    if desc := strings.TrimSpace(cs.Desc); desc != "" {
+        io.WriteString(w, desc+"\n\n")
+    }
+    if cs.Tags != "" {
+        io.WriteString(w, "tags: "+strings.Replace(cs.Tags, ",", ", ", -1)+"\n\n")
+    }
+
+    files := cs.AllFiles()
+    if len(files) == 0 {
+        writeCodeBlock(w, cs.Code, cs.EffectiveLanguage())
+    }
+    for _, file := range files {
+        io.WriteString(w, "file: "+file.Name+"\n\n")
+        writeCodeBlock(w, file.Content, LanguageOfFile(file.Name, file.Content))
+    }
+
+    // test command and expected output follow the code as blocks of their own.
+    if cs.Test != "" {
+        writeCodeBlock(w, cs.Test, testFence)
+    }
+    if cs.Expect != "" {
+        writeCodeBlock(w, cs.Expect, expectFence)
+    }
+}
+
+// categoryFileName is the name of the file a category is exported to. A
+// name already in use, compared case insensitively, gets a number.
+func categoryFileName(cate string, used map[string]bool) string {
+    base := strings.Replace(cateHeading(cate), string(CategorySep), "-", -1)
+    name := base + ".md"
+    for n := 2; used[strings.ToLower(name)]; n++ {
+        name = base + "-" + strconv.Itoa(n) + ".md"
+    }
+    used[strings.ToLower(name)] = true
+    return name
+}
+
+// ExportMarkdown writes segs as Markdown to fpath, or stdout if fpath is
+// empty. With splitDir set, each category goes to its own file in
+// splitDir and README.md links to them.
+func (op *Operator) ExportMarkdown(segs []CodeSegment, fpath string, splitDir string) {
+    if op.err != nil {
+        return
+    }
+    if len(segs) == 0 {
+        op.err = errors.New("no code segment to export.")
+        return
+    }
+
+    if splitDir == "" {
+        var buf bytes.Buffer
+        WriteMarkdown(&buf, "Code Segments", segs)
+        if fpath == "" {
+            fmt.Print(buf.String())
+            return
+        }
+        op.err = ioutil.WriteFile(fpath, buf.Bytes(), 0644)
+        return
+    }
+
+    if op.err = os.MkdirAll(splitDir, 0770); op.err != nil {
+        return
+    }
+    cates, byCate := groupByCategory(segs)
+    var index bytes.Buffer
+    index.WriteString("# Code Segments\n\n")
+    used := map[string]bool{"readme.md": true}
+    for _, cate := range cates {
+        var buf bytes.Buffer
+        WriteMarkdown(&buf, cateHeading(cate), byCate[cate])
+        name := categoryFileName(cate, used)
+        if op.err = ioutil.WriteFile(filepath.Join(splitDir, name), buf.Bytes(), 0644); op.err != nil {
+            return
+        }
+        fmt.Fprintf(&index, "- [%s](%s) (%d)\n", cateHeading(cate), name, len(byCate[cate]))
+    }
+    if op.err = ioutil.WriteFile(filepath.Join(splitDir, "README.md"), index.Bytes(), 0644); op.err == nil {
+        fmt.Printf("exported %d code segments in %d categories to %s\n", len(segs), len(cates), splitDir)
+    }
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestMarkdownRoundTrip(t *testing.T) {
+    segs := []CodeSegment{
+        {Id: "a", Category: "go/strings", Tags: "trim,space", Desc: "Trim spaces.\nBoth ends.\n\nA second paragraph.",
+            Code: "fmt.Println(strings.TrimSpace(\"  x  \"))", Language: "go", Test: "go run .", Expect: "x"},
+        {Id: "b", Category: "shell", Tags: "multi", Desc: "Two files.", Code: "echo a", Language: "shell",
+            Files: []CodeFile{{"a.sh", "echo a"}, {"b.sh", "echo b"}}, Test: "sh a.sh", Expect: "a"},
+    }
+
+    var buf bytes.Buffer
+    WriteMarkdown(&buf, "Code Segments", segs)
+    dir, err := ioutil.TempDir("", "rcs-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    fpath := filepath.Join(dir, "export.md")
+    if err = ioutil.WriteFile(fpath, buf.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    parsed, err := ParseMarkdown(fpath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(parsed) != len(segs) {
+        t.Fatalf("parsed %d segments, want %d:\n%s", len(parsed), len(segs), buf.String())
+    }
+    for i, want := range segs {
+        got := parsed[i]
+        // ids are given by the store on import.
+        got.Id = want.Id
+        if !got.Equal(want) {
+            t.Errorf("segment %d:\ngot  %+v\nwant %+v", i, got, want)
+        }
+    }
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+var (
+    headingPattern = regexp.MustCompile("^(#{1,6})\\s+(.*?)\\s*#*\\s*$")
+    fencePattern   = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([^`\\s]*)")
+    tagsPattern    = regexp.MustCompile(`(?i)^\s*\**tags\**\s*:\**\s*(.*)$`)
+    filePattern    = regexp.MustCompile(`(?i)^\s*\**file\**\s*:\**\s*(\S+)\s*$`)
+)
+
+// headingToCategory makes a category level of a heading, eg: "Net HTTP"
+// -> net-http.
+func headingToCategory(heading string) string {
+    var sb strings.Builder
+    for _, r := range strings.ToLower(strings.TrimSpace(heading)) {
+        if unicode.IsSpace(r) {
+            r = '-'
+        }
+        if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(categoryPunct, r) {
+            sb.WriteRune(r)
+        }
+    }
+    return strings.Trim(sb.String(), "-")
+}
+
+// parseTagLine turns "a, b c, `d`" into the tag string "a,b-c,d".
+func parseTagLine(line string) string {
+    tags := []string{}
+    for _, tag := range strings.Split(line, ",") {
+        tag = strings.Join(strings.Fields(strings.Trim(strings.TrimSpace(tag), "`*")), "-")
+        if tag != "" {
+            tags = append(tags, tag)
+        }
+    }
+    return strings.Join(tags, ",")
+}
+
+// ParseMarkdown reads code segments from a Markdown document: every fenced
+// code block is a segment, the headings above it its category levels, a
+// "tags: a, b" line its tags and the paragraph before it its description.
+// A "file: name" line before a block makes consecutive blocks the files of
+// one segment. A "test" and an "expect" block after a segment are its test
+// command and expected output. Documents written by rcs export are read
+// back as written.
+func ParseMarkdown(fpath string) ([]CodeSegment, error) {
+    f, err := os.Open(fpath)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    lines := []string{}
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+    if err = scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    exported := false
+    for _, line := range lines {
+        if strings.TrimSpace(line) != "" {
+            exported = strings.TrimSpace(line) == markdownMarker
+            break
+        }
+    }
+
+    segs := []CodeSegment{}
+    headings := make([]string, 6)
+    var tags, fileName string
+    paragraph := []string{}
+    // lastIsFile is set while blocks with a file name follow each other.
+    lastIsFile := false
+
+    var category = func() string {
+        if exported {
+            return headings[1]
+        }
+        levels := []string{}
+        for _, h := range headings {
+            if h != "" {
+                levels = append(levels, h)
+            }
+        }
+        return strings.Join(levels, string(CategorySep))
+    }
+
+    for i := 0; i < len(lines); i++ {
+        line := lines[i]
+        if m := fencePattern.FindStringSubmatch(line); m != nil {
+            fence, lang := m[1], m[2]
+            start := i
+            code := []string{}
+            closed := false
+            for i++; i < len(lines); i++ {
+                if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) && strings.Trim(strings.TrimSpace(lines[i]), fence[:1]) == "" {
+                    closed = true
+                    break
+                }
+                code = append(code, lines[i])
+            }
+            if !closed {
+                return nil, errors.New("line " + strconv.Itoa(start+1) + ": code block is not closed.")
+            }
+            if (lang == testFence || lang == expectFence) && len(segs) > 0 && len(paragraph) == 0 {
+                if lang == testFence {
+                    segs[len(segs)-1].Test = strings.Join(code, "\n")
+                } else {
+                    segs[len(segs)-1].Expect = strings.Join(code, "\n")
+                }
+                fileName = ""
+                continue
+            }
+            if lang, err = NormalizeLanguage(lang); err != nil {
+                lang = ""
+            }
+
+            content := strings.Join(code, "\n")
+            if fileName != "" && lastIsFile && len(paragraph) == 0 {
+                cs := &segs[len(segs)-1]
+                cs.Files = append(cs.Files, CodeFile{fileName, content})
+            } else {
+                cs := CodeSegment{Category: category(), Tags: tags, Desc: strings.Join(paragraph, "\n"), Code: content, Language: lang}
+                if fileName != "" {
+                    cs.Files = []CodeFile{{fileName, content}}
+                }
+                segs = append(segs, cs)
+            }
+            lastIsFile = fileName != ""
+            fileName = ""
+            paragraph = []string{}
+            continue
+        }
+
+        if m := headingPattern.FindStringSubmatch(line); m != nil {
+            level := len(m[1])
+            text := m[2]
+            if exported {
+                if level == 2 {
+                    headings[1] = text
+                    if text == "uncategorized" || text == "Contents" {
+                        headings[1] = ""
+                    }
+                }
+            } else {
+                headings[level-1] = headingToCategory(text)
+                for j := level; j < len(headings); j++ {
+                    headings[j] = ""
+                }
+            }
+            tags, fileName, paragraph, lastIsFile = "", "", []string{}, false
+            continue
+        }
+
+        if m := tagsPattern.FindStringSubmatch(line); m != nil {
+            tags = parseTagLine(m[1])
+        } else if m := filePattern.FindStringSubmatch(line); m != nil {
+            fileName = strings.Trim(m[1], "`*")
+        } else if strings.TrimSpace(line) == "" {
+            // a blank line ends a paragraph, the next text starts a new one.
+            if len(paragraph) > 0 && paragraph[len(paragraph)-1] != "" {
+                paragraph = append(paragraph, "")
+            }
+        } else if !strings.HasPrefix(strings.TrimSpace(line), "<!--") {
+            // only the last paragraph describes a block, exported
+            // documents keep all paragraphs of a description.
+            if len(paragraph) > 0 && paragraph[len(paragraph)-1] == "" && !exported {
+                paragraph = []string{}
+            }
+            paragraph = append(paragraph, strings.TrimSpace(line))
+            lastIsFile = false
+        }
+    }
+
+    for i := range segs {
+        // a single file is stored as a plain segment.
+        if len(segs[i].Files) == 1 {
+            segs[i].Files = nil
+        }
+        segs[i].Desc = strings.TrimRight(segs[i].Desc, "\n")
+    }
+    return segs, nil
+}
+
+// ImportMarkdown previews the segments of Markdown document fpath with the
+// results of the usual validation, tag suggestion and duplicate checks, then
+// adds them without further prompts. Without yes it asks first, or only
+// previews when not on a terminal.
+func (op *Operator) ImportMarkdown(fpath string, yes bool) {
+    if op.err != nil {
+        return
+    }
+    segs, err := ParseMarkdown(fpath)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if len(segs) == 0 {
+        op.err = errors.New("no code block found in " + fpath + ".")
+        return
+    }
+
+    // ids are made of category and tags, so those have to be unique too.
+    existing := map[string]string{}
+    stored := op.store.Search("", "")
+    for _, cs := range stored {
+        existing[contentHash(cs)] = cs.Id
+        existing[cs.Category+"|"+cs.Tags] = cs.Id
+    }
+
+    head := []string{"#    ", "CATEGORY            ", "TAGS                ", "LANG        ", "DESC"}
+    format := fmt.Sprintf("%%-%ds%%-%ds%%-%ds%%-%ds%%s\n", len(head[0]), len(head[1]), len(head[2]), len(head[3]))
+    fmt.Print(strings.Join(head, "") + "\n")
+    toAdd := []int{}
+    seen := map[string]int{}
+    for i, cs := range segs {
+        notes := []string{}
+        cs = trimSegment(cs)
+        if cs.Tags == "" {
+            if cs.Tags = op.suggestedTagStr(cs); cs.Tags != "" {
+                notes = append(notes, "suggested tags")
+            }
+        }
+        if canonicalTagsOnAdd && op.aliases != nil {
+            cs.Tags = op.aliases.CanonicalTagStr(cs.Tags)
+        }
+        cs, err = normalizeSegment(cs)
+        if err == nil {
+            cs, err = op.formatGo(cs, func(name string) bool {
+                if op.goCheck.Format {
+                    notes = append(notes, name+" gofmt formatted")
+                } else {
+                    notes = append(notes, name+" not gofmt formatted")
+                }
+                return op.goCheck.Format
+            })
+        }
+        segs[i] = cs
+
+        hash := contentHash(cs)
+        key := cs.Category + "|" + cs.Tags
+        if err != nil {
+            notes = []string{"skip: " + strings.Join(strings.Fields(err.Error()), " ")}
+        } else if id, ok := existing[hash]; ok {
+            notes = []string{"skip: duplicate of " + id}
+        } else if j, ok := seen[hash]; ok {
+            notes = []string{"skip: duplicate of block " + strconv.Itoa(j+1)}
+        } else if id, ok := existing[key]; ok {
+            notes = []string{"skip: same category and tags as " + id}
+        } else if j, ok := seen[key]; ok {
+            notes = []string{"skip: same category and tags as block " + strconv.Itoa(j+1)}
+        } else {
+            // blocks to import are compared too, named by their number.
+            others := stored[:len(stored):len(stored)]
+            for _, j := range toAdd {
+                block := segs[j]
+                block.Id = "block " + strconv.Itoa(j+1)
+                others = append(others, block)
+            }
+            dups, scores := nearDuplicates(cs, others)
+            for k, dup := range dups {
+                notes = append(notes, "near-duplicate of "+dup.Id+" ("+percent(scores[k])+")")
+            }
+            seen[hash] = i
+            seen[key] = i
+            toAdd = append(toAdd, i)
+        }
+        if len(notes) == 0 {
+            notes = append(notes, firstLine(cs.Desc))
+        }
+        fmt.Printf(format, strconv.Itoa(i+1), cs.Category, cs.Tags, cs.EffectiveLanguage(), strings.Join(notes, "; "))
+    }
+
+    if len(toAdd) == 0 {
+        fmt.Println("nothing to import.")
+        return
+    }
+    if !yes {
+        if !isTerminal(os.Stdin) {
+            fmt.Println("preview only, use --yes to import.")
+            return
+        }
+        if !askYesNo("import " + strconv.Itoa(len(toAdd)) + " code segments?") {
+            return
+        }
+    }
+
+    added := 0
+    for _, i := range toAdd {
+        op.addChecked(segs[i])
+        if op.err != nil {
+            fmt.Printf("skipped block %d: %s\n", i+1, op.err)
+            op.err = nil
+            continue
+        }
+        added++
+    }
+    fmt.Printf("%d imported, %d skipped.\n", added, len(segs)-added)
+}
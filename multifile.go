@@ -0,0 +1,176 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// filterByContent keeps the segments whose code, or any of whose files,
+// contains text, case insensitive.
+func filterByContent(segs []CodeSegment, text string) []CodeSegment {
+    if text == "" {
+        return segs
+    }
+    text = strings.ToLower(text)
+    res := []CodeSegment{}
+    for _, cs := range segs {
+        matched := strings.Contains(strings.ToLower(cs.Code), text)
+        for _, file := range cs.AllFiles() {
+            if strings.Contains(strings.ToLower(file.Content), text) {
+                matched = true
+            }
+        }
+        if matched {
+            res = append(res, cs)
+        }
+    }
+    return res
+}
+
+// validateFileName checks the name of a file of a multi-file segment. Names
+// are relative slash separated paths that stay inside the target dir.
+func validateFileName(name string) error {
+    if name == "" {
+        return errors.New("file name can not be empty.")
+    }
+    if strings.ContainsAny(name, "\n\\") || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+        return errors.New("invalid file name: " + name)
+    }
+    for _, part := range strings.Split(name, "/") {
+        if part == "" || part == "." || part == ".." {
+            return errors.New("invalid file name: " + name)
+        }
+    }
+    return nil
+}
+
+// getFile returns the file of cs named name, with includes expanded
+// unless noExpand is set.
+func (op *Operator) getFile(cs CodeSegment, name string, noExpand bool) (CodeFile, error) {
+    files := cs.AllFiles()
+    if len(files) == 0 {
+        return CodeFile{}, errors.New("code segment " + cs.Id + " is not a multi-file segment.")
+    }
+    for _, file := range files {
+        if file.Name != name {
+            continue
+        }
+        if !noExpand {
+            cs.Code = file.Content
+            content, err := op.ExpandIncludes(cs)
+            if err != nil {
+                return CodeFile{}, err
+            }
+            file.Content = content
+        }
+        return file, nil
+    }
+    return CodeFile{}, errors.New("no file " + name + " in code segment " + cs.Id)
+}
+
+// CatFile prints one file of a multi-file segment.
+func (op *Operator) CatFile(ref string, name string, noExpand bool) {
+    if op.err != nil {
+        return
+    }
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+    file, err := op.getFile(cs, name, noExpand)
+    if err != nil {
+        op.err = err
+        return
+    }
+    fmt.Println(file.Content)
+    op.recordUse(cs.Id)
+}
+
+// Materialize writes all files of segment ref into dir, includes expanded.
+// A plain segment is written as one file named after its language. Existing
+// files are not overwritten unless force is set.
+func (op *Operator) Materialize(ref string, dir string, force bool) {
+    if op.err != nil {
+        return
+    }
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    files, err := op.expandedFiles(cs)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    if !force {
+        for _, file := range files {
+            if _, err := os.Stat(filepath.Join(dir, file.Name)); err == nil {
+                op.err = errors.New(filepath.Join(dir, file.Name) + " already exists, use --force to overwrite.")
+                return
+            }
+        }
+    }
+
+    for _, file := range files {
+        fpath := filepath.Join(dir, filepath.FromSlash(file.Name))
+        if op.err = writeCodeFile(fpath, file.Content); op.err != nil {
+            return
+        }
+        fmt.Println(fpath)
+    }
+}
+
+// expandedFiles returns the files of cs, or a single snippet file named
+// after its language, with includes expanded.
+func (op *Operator) expandedFiles(cs CodeSegment) ([]CodeFile, error) {
+    files := cs.AllFiles()
+    if len(files) == 0 {
+        files = []CodeFile{{"snippet" + extOfLanguage(cs.EffectiveLanguage()), cs.Code}}
+    }
+    for i, file := range files {
+        expanded := cs
+        expanded.Code = file.Content
+        content, err := op.ExpandIncludes(expanded)
+        if err != nil {
+            return nil, err
+        }
+        files[i].Content = content
+    }
+    return files, nil
+}
+
+// writeCodeFile writes content to fpath, creating its directory.
+func writeCodeFile(fpath string, content string) error {
+    if err := os.MkdirAll(filepath.Dir(fpath), 0770); err != nil {
+        return err
+    }
+    if !strings.HasSuffix(content, "\n") {
+        content += "\n"
+    }
+    f, err := os.OpenFile(fpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    _, err = f.WriteString(content)
+    return err
+}
+
+// extOfLanguage returns the usual file extension of lang, or ".txt".
+func extOfLanguage(lang string) string {
+    for _, ext := range []string{".go", ".sh", ".py", ".js", ".ts", ".java", ".c", ".cpp", ".rb", ".rs",
+        ".sql", ".html", ".css", ".xml", ".json", ".yaml", ".md", ".el"} {
+        if extLanguages[ext] == lang {
+            return ext
+        }
+    }
+    return ".txt"
+}
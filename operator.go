@@ -6,6 +6,7 @@ import (
     "io/ioutil"
     "os"
     "os/exec"
+    "sort"
     "strings"
     "github.com/satori/go.uuid"
     "strconv"
@@ -13,42 +14,69 @@ import (
 
 const resultDelimiter = "--------------------------------------------------------"
 
+// canonicalTagsOnAdd replaces tag aliases with their canonical tags when
+// adding a code segment, enabled by setting $RCS_CANONICAL_TAGS to true.
+var canonicalTagsOnAdd = os.Getenv("RCS_CANONICAL_TAGS") == "true"
+
 type Operator struct {
-    err   error
-    store Store
+    err         error
+    store       Store
+    aliases     *TagAliases
+    idAliases   *IdAliases
+    goCheck     GoCheck
+    usage       *Usage
+    collections *Collections
 }
 
-func newOperator(store Store) *Operator {
-    return &Operator{nil, store}
+func newOperator(store Store, aliases *TagAliases, idAliases *IdAliases, usage *Usage, collections *Collections) *Operator {
+    return &Operator{nil, store, aliases, idAliases, GoCheck{false, false}, usage, collections}
 }
 
 func (op *Operator) Add(cs CodeSegment) {
-    var validateSegment = func() {
-        cs.Code = strings.TrimSpace(cs.Code)
-        if cs.Code == "" {
-            op.err = errors.New("content can not be empty.")
-        }
-
-        if cs.Category == "" && cs.Tags == "" {
-            op.err = errors.New("category and tags can not be both empty.")
-        }
-
-        if strings.Contains(cs.Category, "|") || strings.Contains(cs.Tags, "|") {
-            op.err = errors.New("category and tagStr can not contains '|' charactor.")
-        }
+    if op.err != nil {
         return
     }
 
+    cs = trimSegment(cs)
+    if cs.Tags == "" {
+        cs.Tags = op.offerTags(cs)
+    }
+    if canonicalTagsOnAdd && op.aliases != nil {
+        cs.Tags = op.aliases.CanonicalTagStr(cs.Tags)
+    }
+    cs, op.err = normalizeSegment(cs)
     if op.err != nil {
         return
     }
-    validateSegment()
+    cs, op.err = op.checkGo(cs)
     if op.err != nil {
         return
     }
+    if op.err = op.checkNearDuplicates(cs); op.err != nil {
+        return
+    }
+    op.addChecked(cs)
+}
 
-    op.err = op.store.Add(cs)
-    return
+// trimSegment trims the code of cs and detects its language if not set.
+func trimSegment(cs CodeSegment) CodeSegment {
+    cs.Code = strings.TrimSpace(cs.Code)
+    for i := range cs.Files {
+        cs.Files[i].Content = strings.TrimRight(cs.Files[i].Content, " \t\r\n")
+    }
+    if cs.Language == "" {
+        cs.Language = DetectLanguage(cs.Code)
+    }
+    return cs
+}
+
+// addChecked stores cs, which has already been normalized and checked.
+func (op *Operator) addChecked(cs CodeSegment) {
+    var id string
+    id, op.err = op.store.Add(cs)
+    if op.err == nil && op.usage != nil {
+        op.usage.RecordAdd(id)
+    }
 }
 
 func (op *Operator) Update(cs CodeSegment) {
@@ -57,6 +85,17 @@ func (op *Operator) Update(cs CodeSegment) {
         return
     }
 
+    // empty fields are kept unchanged by the store, only check the given ones.
+    if cs.Category, op.err = normalizeCategory(cs.Category); op.err != nil {
+        return
+    }
+    if cs.Tags, op.err = normalizeTags(cs.Tags); op.err != nil {
+        return
+    }
+    if cs.Language, op.err = NormalizeLanguage(cs.Language); op.err != nil {
+        return
+    }
+
     op.err = op.store.Update(cs)
 }
 
@@ -69,18 +108,42 @@ func (op *Operator) Append(id string, extraContent string) {
     op.err = op.store.Append(id, extraContent)
 }
 
-func (op *Operator) Search(category string, tags string) {
-    matchedCs := op.store.Search(category, tags)
+// Search prints the matched segments. related adds a footer of similar
+// segments to each, which is always shown for a single match.
+func (op *Operator) Search(category string, tags string, lang string, grep string, related bool) {
+    if lang, op.err = NormalizeLanguage(lang); op.err != nil {
+        return
+    }
+    matchedCs := filterByContent(filterByLanguage(op.searchSynonyms(category, tags), lang), grep)
+    op.rankByUsage(matchedCs)
     size := len(matchedCs)
     if size > 10 {
         fmt.Println("Found", size, "matched code segments, print first 10 as below:")
     } else {
         fmt.Println("Found", size, "matched code segments, print as below:")
     }
+    printer := newPrinter()
+    printer.Terms = strings.FieldsFunc(category+","+tags, func(r rune) bool { return r == ',' })
+    if op.aliases != nil {
+        for _, t := range strings.FieldsFunc(tags, func(r rune) bool { return r == ',' }) {
+            printer.Terms = append(printer.Terms, op.aliases.Synonyms(t)[1:]...)
+        }
+    }
+    if grep != "" {
+        printer.Terms = append(printer.Terms, grep)
+    }
+    var idx *tfidfIndex
+    if related || size == 1 {
+        idx = newTfidfIndex(op.store.Search("", ""))
+    }
     for i, cs := range matchedCs {
         if i < 10 {
             fmt.Println(resultDelimiter)
-            cs.PrintToScreen()
+            printer.Print(os.Stdout, cs)
+            if idx != nil {
+                segs, scores := idx.related(cs.Id, RelatedNum)
+                printer.printRelated(os.Stdout, segs, scores)
+            }
         } else {
             break
         }
@@ -93,6 +156,12 @@ func (op *Operator) Remove(id string) {
         return
     }
     op.err = op.store.Remove(id)
+    if op.err == nil && op.usage != nil {
+        op.usage.Forget(id)
+    }
+    if op.err == nil && op.collections != nil {
+        op.err = op.collections.Forget(id)
+    }
 }
 
 func (op *Operator) Merge(ids ...string) {
@@ -106,6 +175,7 @@ func (op *Operator) Merge(ids ...string) {
     }
 
     var cate string
+    var lang string
     var allTags []string
     var desc string
     var code string
@@ -118,6 +188,12 @@ func (op *Operator) Merge(ids ...string) {
 
         if i == 0 {
             cate = cs.Category
+            lang = cs.Language
+        }
+
+        if len(cs.Files) > 0 {
+            op.err = errors.New("can not merge multi-file segment " + cs.Id + ".")
+            return
         }
 
         desc = desc + "\n" + cs.Desc
@@ -139,13 +215,29 @@ func (op *Operator) Merge(ids ...string) {
     desc = strings.TrimSpace(desc)
     code = strings.TrimSpace(code)
     allTagsStr := strings.Join(allTags, ",")
-    mergedCodeSegment := CodeSegment{"", cate, allTagsStr, desc, code}
+    mergedCodeSegment := CodeSegment{"", cate, allTagsStr, desc, code, lang, nil, "", ""}
+    if _, op.err = normalizeSegment(mergedCodeSegment); op.err != nil {
+        return
+    }
     for _, id := range ids {
         op.Remove(id)
     }
     op.Add(mergedCodeSegment)
 }
 
+func (op *Operator) getByIds(ids []string) []CodeSegment {
+    segs := []CodeSegment{}
+    for _, id := range ids {
+        cs, err := op.store.GetById(id)
+        if err != nil {
+            op.err = err
+            return nil
+        }
+        segs = append(segs, cs)
+    }
+    return segs
+}
+
 func (op *Operator) Edit(id string) {
     cs, err := op.store.GetById(id)
     if err != nil {
@@ -160,46 +252,232 @@ func (op *Operator) Edit(id string) {
         op.err = err
         return
     }
-    defer tmpFile.Close()
+    tmpFile.Close()
+    defer os.Remove(tmpFile.Name())
 
-    cs.PrintToFile(tmpFile.Name())
+    if err = cs.PrintToFile(tmpFile.Name()); err != nil {
+        op.err = err
+        return
+    }
 
-    path, err := exec.LookPath("vi")
+    before, err := ioutil.ReadFile(tmpFile.Name())
     if err != nil {
-        op.err = errors.New("Error while looking for vi: " + err.Error())
+        op.err = err
         return
     }
 
-    cmd := exec.Command(path, tmpFile.Name())
-    cmd.Stdin = os.Stdin
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
+    // on a syntax error the file is opened again until it is fixed or the
+    // edit is given up.
+    for {
+        if err = runEditor(tmpFile.Name()); err != nil {
+            op.err = errors.New("editor exited with error, edit aborted: " + err.Error())
+            return
+        }
 
-    err = cmd.Start()
+        after, err := ioutil.ReadFile(tmpFile.Name())
+        if err != nil {
+            op.err = err
+            return
+        }
+
+        if strings.TrimSpace(string(after)) == "" {
+            op.err = errors.New("edited file is empty, edit aborted.")
+            return
+        }
+
+        if string(before) == string(after) {
+            fmt.Println("no changes.")
+            return
+        }
+
+        var newCs CodeSegment
+        err = (&newCs).ReadFromFile(tmpFile.Name())
+        if err != nil {
+            op.err = err
+            return
+        }
+
+        // the id identifies the segment being edited, it is not editable.
+        newCs.Id = cs.Id
+        if newCs.Equal(cs) {
+            fmt.Println("no changes.")
+            return
+        }
+
+        if newCs, op.err = normalizeChange(cs, newCs); op.err != nil {
+            return
+        }
+
+        if newCs, err = op.checkGo(newCs); err != nil {
+            fmt.Println(err)
+            if askYesNo("edit again?") {
+                continue
+            }
+            op.err = errors.New("edit aborted.")
+            return
+        }
+
+        op.err = op.store.Replace(newCs)
+        return
+    }
+}
+
+// BulkEdit opens all segments in one editor session and applies the
+// resulting adds, updates and deletes as one transaction.
+func (op *Operator) BulkEdit(segs []CodeSegment) {
+    if op.err != nil {
+        return
+    }
+    if len(segs) == 0 {
+        op.err = errors.New("no code segment to edit.")
+        return
+    }
+
+    tmpFile, err := ioutil.TempFile(os.TempDir(), uuid.NewV4().String())
     if err != nil {
         op.err = err
         return
     }
+    tmpFile.Close()
+    defer os.Remove(tmpFile.Name())
 
-    err = cmd.Wait()
+    if err = WriteSegmentsToFile(tmpFile.Name(), segs); err != nil {
+        op.err = err
+        return
+    }
+
+    if err = runEditor(tmpFile.Name()); err != nil {
+        op.err = errors.New("editor exited with error, edit aborted: " + err.Error())
+        return
+    }
 
+    edited, err := ReadSegmentsFromFile(tmpFile.Name())
     if err != nil {
         op.err = err
         return
     }
+    if len(edited) == 0 {
+        op.err = errors.New("edited document is empty, edit aborted.")
+        return
+    }
 
-    //fmt.Println("tmpFile: ", tmpFile.Name())
-    err = (&cs).ReadFromFile(tmpFile.Name())
+    changes, err := diffSegments(segs, edited)
     if err != nil {
         op.err = err
         return
     }
-    //cs.PrintToScreen()
 
-    oldId := cs.Id
-    cs.Id = ""
-    op.Remove(oldId)
-    op.Add(cs)
+    if changes.IsEmpty() {
+        fmt.Println("no changes.")
+        return
+    }
+
+    for _, list := range [][]CodeSegment{changes.Added, changes.Updated} {
+        for i := range list {
+            if list[i], op.err = op.checkGo(list[i]); op.err != nil {
+                return
+            }
+        }
+    }
+
+    if len(changes.Removed) > 0 {
+        fmt.Println("code segments to remove:")
+        for _, id := range changes.Removed {
+            fmt.Println("    " + id)
+        }
+        for _, id := range changes.Removed {
+            op.warnDependents(id)
+        }
+        if !askYesNo("remove " + strconv.Itoa(len(changes.Removed)) + " code segments?") {
+            op.err = errors.New("edit aborted.")
+            return
+        }
+    }
+
+    op.err = op.store.Commit(changes)
+    for _, id := range changes.Removed {
+        if op.err == nil && op.usage != nil {
+            op.usage.Forget(id)
+        }
+        if op.err == nil && op.collections != nil {
+            op.err = op.collections.Forget(id)
+        }
+    }
+    if op.err == nil {
+        fmt.Printf("%d added, %d updated, %d removed.\n", len(changes.Added), len(changes.Updated), len(changes.Removed))
+    }
+}
+
+// diffSegments compares the segments given to the editor with the ones read
+// back and works out what has to be written to the store.
+func diffSegments(origin []CodeSegment, edited []CodeSegment) (changes ChangeSet, err error) {
+    originMap := map[string]CodeSegment{}
+    for _, cs := range origin {
+        originMap[cs.Id] = cs
+    }
+
+    seen := map[string]bool{}
+    for _, cs := range edited {
+        cs.Code = strings.TrimSpace(cs.Code)
+        if cs.Id == "" {
+            if cs, err = normalizeSegment(cs); err != nil {
+                err = errors.New("new segment: " + err.Error())
+                return
+            }
+            changes.Added = append(changes.Added, cs)
+            continue
+        }
+
+        old, ok := originMap[cs.Id]
+        if !ok {
+            err = errors.New("unknown id in edited document: " + cs.Id)
+            return
+        }
+        if seen[cs.Id] {
+            err = errors.New("id appears more than once in edited document: " + cs.Id)
+            return
+        }
+        seen[cs.Id] = true
+
+        // unchanged segments are not validated again, they may predate
+        // the current rules.
+        if cs.Equal(old) {
+            continue
+        }
+        if cs, err = normalizeChange(old, cs); err != nil {
+            err = errors.New("segment " + cs.Id + ": " + err.Error())
+            return
+        }
+        if !cs.Equal(old) {
+            changes.Updated = append(changes.Updated, cs)
+        }
+    }
+
+    for _, cs := range origin {
+        if !seen[cs.Id] {
+            changes.Removed = append(changes.Removed, cs.Id)
+        }
+    }
+    return
+}
+
+func runEditor(fpath string) error {
+    path, err := exec.LookPath("vi")
+    if err != nil {
+        return errors.New("Error while looking for vi: " + err.Error())
+    }
+
+    cmd := exec.Command(path, fpath)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+
+    err = cmd.Start()
+    if err != nil {
+        return err
+    }
+
+    return cmd.Wait()
 }
 
 func (op *Operator) ListCates() {
@@ -250,6 +528,46 @@ func (op *Operator) ListCates() {
     }
 }
 
+type cateNode struct {
+    name     string
+    num      int // code segments in this category and its sub categories
+    children map[string]*cateNode
+}
+
+// ListCateTree prints hierarchical categories as a tree, with the number of
+// code segments in each subtree.
+func (op *Operator) ListCateTree() {
+    stats := op.store.GetStats()
+    root := &cateNode{"", 0, map[string]*cateNode{}}
+    for cate, num := range stats.CateNumMap {
+        node := root
+        for _, level := range strings.Split(cate, string(CategorySep)) {
+            child, ok := node.children[level]
+            if !ok {
+                child = &cateNode{level, 0, map[string]*cateNode{}}
+                node.children[level] = child
+            }
+            child.num += num
+            node = child
+        }
+    }
+
+    var printNode func(node *cateNode, depth int)
+    printNode = func(node *cateNode, depth int) {
+        names := []string{}
+        for name := range node.children {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            child := node.children[name]
+            fmt.Printf("%-40s%d\n", strings.Repeat("    ", depth)+child.name, child.num)
+            printNode(child, depth+1)
+        }
+    }
+    printNode(root, 0)
+}
+
 func (op *Operator) ListTags() {
     stats := op.store.GetStats()
     head := []string{"INDEX    ", "TAG                    ", "RCS-NUM ", "CATEGORIES    "}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+)
+
+// pagedCommands are the commands whose output goes through the pager.
+var pagedCommands = []string{"search", "list-c", "list-t", "top", "recent", "stale", "similar", "dupes"}
+
+const defaultPager = "less -R"
+
+// stopPager shows the output captured since startPager, a no-op if the
+// pager was not started.
+var stopPager = func() {}
+
+// exit stops the pager before exiting, so the captured output is not lost.
+func exit(code int) {
+    stopPager()
+    os.Exit(code)
+}
+
+// stdoutTTY is checked once at start, before stdout may be replaced by the
+// pager pipe.
+var stdoutTTY = isTerminal(os.Stdout)
+
+// startPager captures everything written to os.Stdout until the returned
+// function is called. The captured output is then shown through $PAGER if
+// it is longer than the terminal height, or written out directly otherwise.
+// Nothing is captured if stdout is not a terminal.
+func startPager() func() {
+    if !stdoutTTY {
+        return func() {}
+    }
+
+    r, w, err := os.Pipe()
+    if err != nil {
+        return func() {}
+    }
+
+    stdout := os.Stdout
+    os.Stdout = w
+    done := make(chan []byte)
+    go func() {
+        out, _ := ioutil.ReadAll(r)
+        r.Close()
+        done <- out
+    }()
+
+    return func() {
+        w.Close()
+        os.Stdout = stdout
+        out := <-done
+
+        height := terminalHeight(stdout)
+        if height <= 0 || bytes.Count(out, []byte("\n")) < height {
+            stdout.Write(out)
+            return
+        }
+
+        if err := runPager(out); err != nil {
+            stdout.Write(out)
+        }
+    }
+}
+
+func runPager(out []byte) error {
+    pager := strings.Fields(os.Getenv("PAGER"))
+    if len(pager) == 0 {
+        pager = strings.Fields(defaultPager)
+    }
+
+    cmd := exec.Command(pager[0], pager[1:]...)
+    cmd.Stdin = bytes.NewReader(out)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    return cmd.Run()
+}
+
+// terminalHeight returns $LINES if set, or asks the terminal of f.
+func terminalHeight(f *os.File) int {
+    if lines, err := strconv.Atoi(os.Getenv("LINES")); err == nil {
+        return lines
+    }
+    rows, _ := ttySize(f)
+    return rows
+}
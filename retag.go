@@ -0,0 +1,210 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// RenameTag renames tag oldTag to newTag in all code segments.
+func (op *Operator) RenameTag(oldTag string, newTag string, dryRun bool) {
+    if oldTag == "" || newTag == "" {
+        op.err = errors.New("tag name can not be empty.")
+        return
+    }
+    op.MergeTags([]string{oldTag}, newTag, dryRun)
+}
+
+// MergeTags replaces every tag in tags with into in all code segments.
+func (op *Operator) MergeTags(tags []string, into string, dryRun bool) {
+    if len(tags) == 0 || into == "" {
+        op.err = errors.New("tags to merge and target tag can not be empty.")
+        return
+    }
+    op.rewriteAll(dryRun, func(cs CodeSegment) CodeSegment {
+        cs.Tags = replaceTags(cs.Tags, tags, into)
+        return cs
+    })
+}
+
+// DeleteTag removes tag from all code segments.
+func (op *Operator) DeleteTag(tag string, dryRun bool) {
+    if tag == "" {
+        op.err = errors.New("tag name can not be empty.")
+        return
+    }
+    op.rewriteAll(dryRun, func(cs CodeSegment) CodeSegment {
+        cs.Tags = replaceTags(cs.Tags, []string{tag}, "")
+        return cs
+    })
+}
+
+// RenameCategory renames category oldCate to newCate in all code segments,
+// sub categories of oldCate are moved along.
+func (op *Operator) RenameCategory(oldCate string, newCate string, dryRun bool) {
+    if oldCate == "" || newCate == "" {
+        op.err = errors.New("category name can not be empty.")
+        return
+    }
+    op.rewriteAll(dryRun, func(cs CodeSegment) CodeSegment {
+        if IsSubCategory(strings.ToUpper(cs.Category), strings.ToUpper(oldCate)) {
+            cs.Category = newCate + cs.Category[len(oldCate):]
+        }
+        return cs
+    })
+}
+
+// MigrateCategories converts legacy hyphenated categories like go-strings
+// into hierarchical ones like go/strings. A category is converted only if
+// its first word is a category itself or is shared by other hyphenated
+// categories, so that names like start-script are left alone.
+func (op *Operator) MigrateCategories(dryRun bool) {
+    if op.err != nil {
+        return
+    }
+
+    stats := op.store.GetStats()
+    prefixNum := map[string]int{}
+    for _, cate := range stats.AllCates {
+        if i := strings.Index(cate, "-"); i > 0 {
+            prefixNum[cate[:i]]++
+        }
+    }
+
+    op.rewriteAll(dryRun, func(cs CodeSegment) CodeSegment {
+        i := strings.Index(cs.Category, "-")
+        if i <= 0 {
+            return cs
+        }
+        prefix := cs.Category[:i]
+        if ArrContains(stats.AllCates, prefix) || prefixNum[prefix] > 1 {
+            cs.Category = strings.Replace(cs.Category, "-", string(CategorySep), -1)
+        }
+        return cs
+    })
+}
+
+// rewriteAll applies fn to every code segment and writes the changed ones
+// back in one Commit. With dryRun it only prints the affected segments.
+func (op *Operator) rewriteAll(dryRun bool, fn func(cs CodeSegment) CodeSegment) {
+    if op.err != nil {
+        return
+    }
+
+    changes := ChangeSet{}
+    for _, cs := range op.store.Search("", "") {
+        newCs := fn(cs)
+        if newCs.Equal(cs) {
+            continue
+        }
+        newCs, err := normalizeChange(cs, newCs)
+        if err != nil {
+            op.err = errors.New("segment " + cs.Id + ": " + err.Error())
+            return
+        }
+        changes.Updated = append(changes.Updated, newCs)
+
+        fmt.Printf("%s  %s|%s  =>  %s|%s\n", cs.Id, cs.Category, cs.Tags, newCs.Category, newCs.Tags)
+    }
+
+    if changes.IsEmpty() {
+        fmt.Println("no code segment affected.")
+        return
+    }
+
+    if dryRun {
+        fmt.Println(len(changes.Updated), "code segments would be changed (dry run).")
+        return
+    }
+
+    op.err = op.store.Commit(changes)
+    if op.err == nil {
+        fmt.Println(len(changes.Updated), "code segments changed.")
+    }
+}
+
+// replaceTags replaces tags in tagStr that equal (case insensitive) any of
+// from with to, keeping the order and dropping duplicates. An empty to
+// deletes the tags.
+func replaceTags(tagStr string, from []string, to string) string {
+    var isFrom = func(t string) bool {
+        for _, f := range from {
+            if strings.EqualFold(f, t) {
+                return true
+            }
+        }
+        return false
+    }
+
+    matched := false
+    newTags := []string{}
+    for _, t := range strings.Split(tagStr, ",") {
+        if t == "" {
+            continue
+        }
+        if isFrom(t) {
+            t = to
+            matched = true
+        }
+        if t != "" && !ArrContains(newTags, t) {
+            newTags = append(newTags, t)
+        }
+    }
+    if !matched {
+        return tagStr
+    }
+    return strings.Join(newTags, ",")
+}
+
+// Retag replaces the tags of code segment id, keeping its id and position.
+func (op *Operator) Retag(id string, tagStr string) {
+    if op.err != nil {
+        return
+    }
+
+    cs, err := op.store.GetById(id)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    cs.Tags = tagStr
+    if cs, op.err = normalizeSegment(cs); op.err != nil {
+        return
+    }
+    op.err = op.store.Replace(cs)
+}
+
+// AliasTag makes alias a synonym of tag. Searching either one finds both.
+func (op *Operator) AliasTag(alias string, tag string) {
+    if op.err != nil {
+        return
+    }
+    op.err = op.aliases.Add(alias, tag)
+}
+
+func (op *Operator) UnaliasTag(alias string) {
+    if op.err != nil {
+        return
+    }
+    op.err = op.aliases.Remove(alias)
+}
+
+func (op *Operator) ListAliases() {
+    for _, alias := range op.aliases.Aliases() {
+        fmt.Printf("%-24s%s\n", strings.ToLower(alias), op.aliases.Canonical(alias))
+    }
+}
+
+// SuggestAliases prints pairs of existing tags that look like synonyms.
+func (op *Operator) SuggestAliases() {
+    stats := op.store.GetStats()
+    pairs := op.aliases.SuggestAliases(stats.AllTags)
+    if len(pairs) == 0 {
+        fmt.Println("no likely synonyms found.")
+        return
+    }
+    for _, pair := range pairs {
+        fmt.Printf("rcs tag alias %s %s\n", pair[0], pair[1])
+    }
+}
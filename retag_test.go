@@ -0,0 +1,77 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// legacyTags has more than MaxTags tags, like segments stored before the
+// limit was added.
+const legacyTags = "basic,command,comment,load,import,csv,text_file,ddl,create,user,database,table"
+
+func newLegacyStore(t *testing.T) (*FileStore, CodeSegment) {
+    dir, err := ioutil.TempDir("", "rcs-test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.RemoveAll(dir) })
+
+    fs := &FileStore{filepath.Join(dir, "segfile.rcs")}
+    cs := CodeSegment{Id: "legacyIdXXXXXXXXXXXXXXXXXXX", Category: "mysql", Tags: legacyTags, Code: "select 1;", Language: "sql"}
+    if err = ioutil.WriteFile(fs.FilePath, []byte(fs.codeSegmentToStr(cs)+"\n"), 0660); err != nil {
+        t.Fatal(err)
+    }
+    return fs, cs
+}
+
+func TestRetagLegacySegment(t *testing.T) {
+    fs, cs := newLegacyStore(t)
+    op := newOperator(fs, nil, nil, nil, nil)
+
+    op.DeleteTag("csv", false)
+    op.RenameTag("basic", "intro", false)
+    if op.err != nil {
+        t.Fatal(op.err)
+    }
+    got, err := fs.GetById(cs.Id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := strings.Replace(strings.Replace(legacyTags, "csv,", "", 1), "basic", "intro", 1)
+    if got.Tags != want {
+        t.Errorf("tags = %q, want %q", got.Tags, want)
+    }
+
+    op.rewriteAll(false, func(cs CodeSegment) CodeSegment {
+        cs.Tags += ",extra"
+        return cs
+    })
+    if op.err == nil {
+        t.Error("adding tags to a segment over the limit should fail")
+    }
+}
+
+func TestDiffLegacySegment(t *testing.T) {
+    _, cs := newLegacyStore(t)
+
+    changes, err := diffSegments([]CodeSegment{cs}, []CodeSegment{cs})
+    if err != nil || !changes.IsEmpty() {
+        t.Errorf("unchanged segment: changes %+v, err %v", changes, err)
+    }
+
+    fewer := cs
+    fewer.Tags = strings.Replace(legacyTags, ",csv", "", 1)
+    changes, err = diffSegments([]CodeSegment{cs}, []CodeSegment{fewer})
+    if err != nil || len(changes.Updated) != 1 {
+        t.Errorf("removing a tag: changes %+v, err %v", changes, err)
+    }
+
+    more := cs
+    more.Tags = legacyTags + ",extra"
+    if _, err = diffSegments([]CodeSegment{cs}, []CodeSegment{more}); err == nil {
+        t.Error("adding a tag to a segment over the limit should fail")
+    }
+}
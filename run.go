@@ -0,0 +1,289 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DefaultRunTimeout is how long rcs run waits before killing a snippet.
+const DefaultRunTimeout = 30 * time.Second
+
+// runnableLanguages are the languages rcs run knows how to execute.
+var runnableLanguages = []string{"shell", "go", "python"}
+
+// stdPackages maps package names used in go snippets to import paths,
+// for snippets that are not a complete file.
+var stdPackages = map[string]string{
+    "bufio":    "bufio",
+    "bytes":    "bytes",
+    "context":  "context",
+    "errors":   "errors",
+    "exec":     "os/exec",
+    "filepath": "path/filepath",
+    "fmt":      "fmt",
+    "http":     "net/http",
+    "io":       "io",
+    "ioutil":   "io/ioutil",
+    "json":     "encoding/json",
+    "math":     "math",
+    "os":       "os",
+    "rand":     "math/rand",
+    "regexp":   "regexp",
+    "sort":     "sort",
+    "strconv":  "strconv",
+    "strings":  "strings",
+    "sync":     "sync",
+    "time":     "time",
+}
+
+// runCommand returns the command executing file of language lang.
+func runCommand(lang string, file CodeFile, args []string) ([]string, error) {
+    switch lang {
+    case "shell":
+        // a shebang line picks the interpreter, eg: #!/bin/bash
+        if strings.HasPrefix(file.Content, "#!") {
+            shebang := strings.Fields(strings.SplitN(file.Content[2:], "\n", 2)[0])
+            if len(shebang) > 0 {
+                return append(append(shebang, file.Name), args...), nil
+            }
+        }
+        return append([]string{"sh", file.Name}, args...), nil
+    case "go":
+        return append([]string{"go", "run", "."}, args...), nil
+    case "python":
+        return append([]string{"python3", file.Name}, args...), nil
+    }
+    return nil, errors.New("can not run " + lang + " code, runnable languages: " + strings.Join(runnableLanguages, ", ") + ".")
+}
+
+// goMain makes a go snippet of declarations or statements a main package,
+// importing the standard packages it uses.
+func goMain(code string) string {
+    w := goWrappers[0]
+    var file *ast.File
+    for _, wrapper := range goWrappers[:3] {
+        f, err := parser.ParseFile(token.NewFileSet(), "", wrapper.prefix+code+wrapper.suffix, 0)
+        if err == nil {
+            w, file = wrapper, f
+            break
+        }
+    }
+    // complete files, and code go run will report errors for, are kept.
+    if w.header == 0 {
+        return code
+    }
+
+    imports := []string{}
+    for _, path := range missingImports(file) {
+        imports = append(imports, `    "`+path+`"`)
+    }
+
+    main := "package main\n\n"
+    if len(imports) > 0 {
+        main += "import (\n" + strings.Join(imports, "\n") + "\n)\n\n"
+    }
+    if w.suffix == "" {
+        return main + code
+    }
+    return main + "func main() {\n" + code + "\n}"
+}
+
+// missingImports returns the standard packages file refers to without
+// importing them. Only names the parser could not resolve count, so
+// locals, strings and comments are not mistaken for packages.
+func missingImports(file *ast.File) []string {
+    unresolved := map[*ast.Ident]bool{}
+    for _, ident := range file.Unresolved {
+        unresolved[ident] = true
+    }
+    imported := map[string]bool{}
+    for _, spec := range file.Imports {
+        path, _ := strconv.Unquote(spec.Path.Value)
+        name := path[strings.LastIndex(path, "/")+1:]
+        if spec.Name != nil {
+            name = spec.Name.Name
+        }
+        imported[name] = true
+    }
+
+    used := map[string]bool{}
+    ast.Inspect(file, func(n ast.Node) bool {
+        if sel, ok := n.(*ast.SelectorExpr); ok {
+            if ident, ok := sel.X.(*ast.Ident); ok && unresolved[ident] && !imported[ident.Name] {
+                if path, ok := stdPackages[ident.Name]; ok {
+                    used[path] = true
+                }
+            }
+        }
+        return true
+    })
+
+    paths := []string{}
+    for path := range used {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    return paths
+}
+
+// goModule returns the go.mod of the scratch module.
+func goModule() string {
+    mod := "module snippet\n"
+    out, err := exec.Command("go", "env", "GOVERSION").Output()
+    if version := strings.TrimPrefix(strings.TrimSpace(string(out)), "go"); err == nil && version != "" {
+        parts := strings.Split(version, ".")
+        mod += "\ngo " + strings.Join(parts[:minInt(len(parts), 2)], ".") + "\n"
+    }
+    return mod
+}
+
+// Run writes segment ref into a scratch directory and runs it with args,
+// killing it after timeout. The directory is removed unless keep is set.
+func (op *Operator) Run(ref string, args []string, timeout time.Duration, keep bool) {
+    if op.err != nil {
+        return
+    }
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    dir, lang, entry, err := op.writeScratch(cs)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if keep {
+        defer fmt.Println("scratch directory kept:", dir)
+    } else {
+        defer os.RemoveAll(dir)
+    }
+
+    command, err := runCommand(lang, entry, args)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    op.recordUse(cs.Id)
+    output, elapsed, err := runInDir(dir, command, timeout)
+    fmt.Print(output)
+    if output != "" && !strings.HasSuffix(output, "\n") {
+        fmt.Println()
+    }
+    fmt.Println(resultDelimiter)
+    if err != nil {
+        op.err = err
+        return
+    }
+    fmt.Printf("exit status 0, took %s\n", elapsed)
+}
+
+// writeScratch writes the files of cs into a new scratch directory and
+// returns it with the language and file of the entry point, the first
+// runnable file. Placeholders of files that declare some take their
+// defaults, shell files are never rendered implicitly.
+func (op *Operator) writeScratch(cs CodeSegment) (dir string, lang string, entry CodeFile, err error) {
+    files, err := op.expandedFiles(cs)
+    if err != nil {
+        return
+    }
+    for i, file := range files {
+        if len(Placeholders(file.Content)) == 0 {
+            continue
+        }
+        fileLang := cs.EffectiveLanguage()
+        if len(cs.Files) > 0 {
+            fileLang = LanguageOfFile(file.Name, file.Content)
+        }
+        if fileLang == "shell" {
+            err = errors.New(file.Name + " has placeholders, shell code is not rendered implicitly, use rcs render id | sh.")
+            return
+        }
+        if files[i].Content, err = RenderTemplate(file.Content, map[string]string{}); err != nil {
+            err = errors.New(err.Error() + ", use rcs render to fill them.")
+            return
+        }
+    }
+
+    index := -1
+    for i, file := range files {
+        lang = LanguageOfFile(file.Name, file.Content)
+        if len(cs.Files) == 0 {
+            lang = cs.EffectiveLanguage()
+        }
+        if ArrContains(runnableLanguages, lang) {
+            index = i
+            break
+        }
+    }
+    if index < 0 {
+        lang = cs.EffectiveLanguage()
+    }
+
+    if lang == "go" {
+        if len(cs.Files) == 0 {
+            files[index].Content = goMain(files[index].Content)
+        }
+        hasMod := false
+        for _, file := range files {
+            hasMod = hasMod || file.Name == "go.mod"
+        }
+        if !hasMod {
+            files = append(files, CodeFile{"go.mod", goModule()})
+        }
+    }
+
+    if dir, err = ioutil.TempDir(os.TempDir(), "rcs-run-"); err != nil {
+        return
+    }
+    for _, file := range files {
+        if err = writeCodeFile(filepath.Join(dir, filepath.FromSlash(file.Name)), file.Content); err != nil {
+            os.RemoveAll(dir)
+            return
+        }
+    }
+    if index >= 0 {
+        entry = files[index]
+    }
+    return
+}
+
+// runInDir runs command in dir and returns its stdout and stderr, killing
+// it after timeout.
+func runInDir(dir string, command []string, timeout time.Duration) (string, time.Duration, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+    cmd.Dir = dir
+    var output bytes.Buffer
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+    killProcessGroup(cmd)
+
+    start := time.Now()
+    err := cmd.Run()
+    elapsed := time.Since(start).Round(time.Millisecond)
+
+    if ctx.Err() == context.DeadlineExceeded {
+        return output.String(), elapsed, errors.New("killed after " + timeout.String() + " timeout.")
+    }
+    if exitErr, ok := err.(*exec.ExitError); ok {
+        err = errors.New(fmt.Sprintf("exit status %d, took %s", exitErr.ExitCode(), elapsed))
+    }
+    return output.String(), elapsed, err
+}
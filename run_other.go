@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import "os/exec"
+
+// killProcessGroup is a no-op, only cmd itself is killed when cancelled.
+func killProcessGroup(cmd *exec.Cmd) {
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Testable reports whether cs has a test command or an expected output.
+func (cs CodeSegment) Testable() bool {
+    return cs.Test != "" || cs.Expect != ""
+}
+
+// normalizeOutput trims trailing spaces of each line and blank lines at
+// the end, so expected output can be written by hand.
+func normalizeOutput(output string) string {
+    lines := strings.Split(strings.Replace(output, "\r\n", "\n", -1), "\n")
+    for i, line := range lines {
+        lines[i] = strings.TrimRight(line, " \t")
+    }
+    return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// testSegment runs the test of cs in a scratch directory, its test command
+// or else the segment itself, and compares the output with Expect.
+func (op *Operator) testSegment(cs CodeSegment, timeout time.Duration) (string, time.Duration, error) {
+    dir, lang, entry, err := op.writeScratch(cs)
+    if err != nil {
+        return "", 0, err
+    }
+    defer os.RemoveAll(dir)
+
+    command := []string{"sh", "-c", cs.Test}
+    if cs.Test == "" {
+        if command, err = runCommand(lang, entry, nil); err != nil {
+            return "", 0, err
+        }
+    }
+
+    output, elapsed, err := runInDir(dir, command, timeout)
+    if err != nil {
+        return output, elapsed, err
+    }
+    if cs.Expect != "" && normalizeOutput(output) != normalizeOutput(cs.Expect) {
+        return output, elapsed, errors.New("output differs from expected output.")
+    }
+    return output, elapsed, nil
+}
+
+// Test runs the tests of the testable segments of segs and reports which
+// pass and which fail.
+func (op *Operator) Test(segs []CodeSegment, timeout time.Duration) {
+    if op.err != nil {
+        return
+    }
+
+    passed, failed := 0, 0
+    for _, cs := range segs {
+        if !cs.Testable() {
+            continue
+        }
+
+        output, elapsed, err := op.testSegment(cs, timeout)
+        if err == nil {
+            passed++
+            fmt.Printf("PASS  %s  %s  (%s)\n", cs.Id, cs.Category, elapsed)
+            continue
+        }
+
+        failed++
+        fmt.Printf("FAIL  %s  %s  (%s)\n", cs.Id, cs.Category, elapsed)
+        fmt.Println("      " + err.Error())
+        if cs.Expect != "" {
+            fmt.Println("      expected:")
+            printIndented(normalizeOutput(cs.Expect))
+        }
+        if output != "" {
+            fmt.Println("      got:")
+            printIndented(normalizeOutput(output))
+        }
+    }
+
+    if passed+failed == 0 {
+        op.err = errors.New("no testable code segment, add one with --test or --expect.")
+        return
+    }
+    fmt.Println(resultDelimiter)
+    if failed > 0 {
+        op.err = errors.New(strconv.Itoa(failed) + " of " + strconv.Itoa(passed+failed) + " tests failed.")
+        return
+    }
+    fmt.Printf("all %d tests passed.\n", passed)
+}
+
+func printIndented(text string) {
+    for _, line := range strings.Split(text, "\n") {
+        fmt.Println("        " + line)
+    }
+}
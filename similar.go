@@ -0,0 +1,164 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// term weights: tags and categories say more about a segment than any
+// single word of its code.
+const (
+    tagWeight  = 3.0
+    cateWeight = 2.0
+    langWeight = 1.0
+    wordWeight = 1.0
+)
+
+// RelatedNum is how many related segments a search footer lists.
+const RelatedNum = 3
+
+// minRelatedScore leaves out segments sharing little more than common words.
+const minRelatedScore = 0.05
+
+var wordPattern = regexp.MustCompile(`[A-Za-z][A-Za-z0-9]+`)
+
+// segmentTerms returns the weighted term frequencies of cs.
+func segmentTerms(cs CodeSegment) map[string]float64 {
+    terms := map[string]float64{}
+    for _, tag := range strings.Split(cs.Tags, ",") {
+        if tag != "" {
+            terms["tag:"+strings.ToLower(tag)] += tagWeight
+        }
+    }
+    // every level of a hierarchical category, so go/net and go/strings
+    // share go.
+    levels := strings.Split(cs.Category, string(CategorySep))
+    for i := range levels {
+        terms["cate:"+strings.ToLower(strings.Join(levels[:i+1], string(CategorySep)))] += cateWeight
+    }
+    if lang := cs.EffectiveLanguage(); lang != "" {
+        terms["lang:"+lang] += langWeight
+    }
+
+    text := cs.Desc + "\n" + cs.Code
+    // the first file is cs.Code.
+    for _, file := range cs.AllFiles()[minInt(1, len(cs.Files)):] {
+        text += "\n" + file.Content
+    }
+    for _, word := range wordPattern.FindAllString(text, -1) {
+        terms[strings.ToLower(word)] += wordWeight
+    }
+    return terms
+}
+
+// tfidfIndex holds the TF-IDF vectors of a set of segments.
+type tfidfIndex struct {
+    segs    []CodeSegment
+    vectors []map[string]float64
+}
+
+func newTfidfIndex(segs []CodeSegment) *tfidfIndex {
+    idx := &tfidfIndex{segs, make([]map[string]float64, len(segs))}
+    df := map[string]int{}
+    for i, cs := range segs {
+        idx.vectors[i] = segmentTerms(cs)
+        for term := range idx.vectors[i] {
+            df[term]++
+        }
+    }
+
+    for _, vec := range idx.vectors {
+        norm := 0.0
+        for term, tf := range vec {
+            vec[term] = tf * math.Log(1+float64(len(segs))/float64(df[term]))
+            norm += vec[term] * vec[term]
+        }
+        norm = math.Sqrt(norm)
+        for term := range vec {
+            vec[term] /= norm
+        }
+    }
+    return idx
+}
+
+// related returns up to n segments most similar to segment id, with their
+// cosine similarity.
+func (idx *tfidfIndex) related(id string, n int) ([]CodeSegment, []float64) {
+    self := -1
+    for i, cs := range idx.segs {
+        if cs.Id == id {
+            self = i
+        }
+    }
+    if self < 0 {
+        return nil, nil
+    }
+
+    order := []int{}
+    scores := make([]float64, len(idx.segs))
+    for i, vec := range idx.vectors {
+        if i == self {
+            continue
+        }
+        for term, w := range vec {
+            scores[i] += w * idx.vectors[self][term]
+        }
+        if scores[i] >= minRelatedScore {
+            order = append(order, i)
+        }
+    }
+    sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+    res, resScores := []CodeSegment{}, []float64{}
+    for _, i := range order[:minInt(n, len(order))] {
+        res = append(res, idx.segs[i])
+        resScores = append(resScores, scores[i])
+    }
+    return res, resScores
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+    return strings.SplitN(s, "\n", 2)[0]
+}
+
+// printRelated writes the related footer of a segment.
+func (p *Printer) printRelated(w io.Writer, segs []CodeSegment, scores []float64) {
+    if len(segs) == 0 {
+        return
+    }
+    fmt.Fprintln(w, p.label("RELATED:"))
+    for i, cs := range segs {
+        fmt.Fprintf(w, "      %s  %s  %s  %s  %s\n", cs.Id, strconv.FormatFloat(scores[i], 'f', 2, 64), cs.Category, cs.Tags, firstLine(cs.Desc))
+    }
+}
+
+// Similar prints the n segments most similar to segment ref by tags,
+// category, description and code.
+func (op *Operator) Similar(ref string, n int) {
+    if op.err != nil {
+        return
+    }
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+
+    segs, scores := newTfidfIndex(op.store.Search("", "")).related(cs.Id, n)
+    if len(segs) == 0 {
+        fmt.Println("no similar code segment.")
+        return
+    }
+
+    rows := [][]string{}
+    for i, s := range segs {
+        rows = append(rows, []string{strconv.FormatFloat(scores[i], 'f', 2, 64), s.Id, s.Category, s.Tags, firstLine(s.Desc)})
+    }
+    printTable([]string{"SCORE", "ID", "CATEGORY", "TAGS", "DESC"}, rows)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "os"
+    "sort"
+    "strings"
+)
+
+const aliasFileName = "aliases.rcs"
+
+// TagAliases is a dictionary of tag synonyms, stored in the codebase next to
+// the segment file as lines of "alias=canonical".
+type TagAliases struct {
+    FilePath string
+    aliases  map[string]string // upper case alias -> canonical tag
+}
+
+func LoadTagAliases(fpath string) (*TagAliases, error) {
+    ta := &TagAliases{fpath, map[string]string{}}
+    f, err := os.Open(fpath)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return ta, nil
+        }
+        return ta, err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        flds := strings.SplitN(line, "=", 2)
+        if len(flds) != 2 {
+            continue
+        }
+        ta.aliases[strings.ToUpper(flds[0])] = flds[1]
+    }
+    return ta, scanner.Err()
+}
+
+func (ta *TagAliases) save() error {
+    f, err := os.OpenFile(ta.FilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    for _, alias := range ta.Aliases() {
+        if _, err = f.WriteString(strings.ToLower(alias) + "=" + ta.aliases[alias] + "\n"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Aliases returns all aliases (upper case) in sorted order.
+func (ta *TagAliases) Aliases() []string {
+    aliases := []string{}
+    for alias := range ta.aliases {
+        aliases = append(aliases, alias)
+    }
+    sort.Strings(aliases)
+    return aliases
+}
+
+// Add makes alias a synonym of canonical and saves the dictionary.
+func (ta *TagAliases) Add(alias string, canonical string) error {
+    alias, err := normalizeTags(alias)
+    if err != nil {
+        return err
+    }
+    canonical, err = normalizeTags(canonical)
+    if err != nil {
+        return err
+    }
+    if alias == "" || canonical == "" {
+        return errors.New("alias and tag can not be empty.")
+    }
+    if strings.Contains(alias, ",") || strings.Contains(canonical, ",") {
+        return errors.New("alias and tag should be one tag each.")
+    }
+    if strings.EqualFold(alias, canonical) {
+        return errors.New("alias and tag are the same.")
+    }
+    if _, ok := ta.aliases[strings.ToUpper(canonical)]; ok {
+        return errors.New(canonical + " is an alias itself, use its canonical tag " + ta.Canonical(canonical) + " instead.")
+    }
+
+    // aliases of the new alias are moved to canonical, aliases never chain.
+    for a, c := range ta.aliases {
+        if strings.EqualFold(c, alias) {
+            ta.aliases[a] = canonical
+        }
+    }
+    ta.aliases[strings.ToUpper(alias)] = canonical
+    return ta.save()
+}
+
+// Remove deletes alias from the dictionary and saves it.
+func (ta *TagAliases) Remove(alias string) error {
+    if _, ok := ta.aliases[strings.ToUpper(alias)]; !ok {
+        return errors.New("alias not found: " + alias)
+    }
+    delete(ta.aliases, strings.ToUpper(alias))
+    return ta.save()
+}
+
+// Canonical returns the canonical tag of tag, or tag itself if it is not an
+// alias.
+func (ta *TagAliases) Canonical(tag string) string {
+    if canonical, ok := ta.aliases[strings.ToUpper(tag)]; ok {
+        return canonical
+    }
+    return tag
+}
+
+// Synonyms returns tag together with its canonical tag and all other aliases
+// of it.
+func (ta *TagAliases) Synonyms(tag string) []string {
+    canonical := ta.Canonical(tag)
+    synonyms := []string{tag}
+    if !strings.EqualFold(canonical, tag) {
+        synonyms = append(synonyms, canonical)
+    }
+    for _, alias := range ta.Aliases() {
+        if strings.EqualFold(ta.aliases[alias], canonical) && !strings.EqualFold(alias, tag) {
+            synonyms = append(synonyms, strings.ToLower(alias))
+        }
+    }
+    return synonyms
+}
+
+// CanonicalTagStr replaces every alias in a comma separated tag string with
+// its canonical tag.
+func (ta *TagAliases) CanonicalTagStr(tagStr string) string {
+    if tagStr == "" {
+        return tagStr
+    }
+    tags := strings.Split(tagStr, ",")
+    for i, t := range tags {
+        tags[i] = ta.Canonical(t)
+    }
+    return strings.Join(tags, ",")
+}
+
+// SuggestAliases looks for pairs of existing tags that are likely synonyms:
+// the same word with different separators or plural, an abbreviation, or a
+// typo. Pairs already in the dictionary are skipped.
+func (ta *TagAliases) SuggestAliases(tags []string) [][2]string {
+    var simplify = func(t string) string {
+        t = strings.ToLower(t)
+        t = strings.Replace(t, "-", "", -1)
+        t = strings.Replace(t, "_", "", -1)
+        return strings.TrimSuffix(t, "s")
+    }
+
+    sorted := append([]string{}, tags...)
+    sort.Strings(sorted)
+
+    pairs := [][2]string{}
+    for i, a := range sorted {
+        for _, b := range sorted[i+1:] {
+            if strings.EqualFold(ta.Canonical(a), ta.Canonical(b)) {
+                continue
+            }
+
+            short, long := a, b
+            if len(short) > len(long) {
+                short, long = long, short
+            }
+
+            sa, sb := simplify(a), simplify(b)
+            if sa == sb || isAbbreviation(short, long) || (len(sa) >= 4 && len(sb) >= 4 && editDistance(sa, sb) <= 1) {
+                pairs = append(pairs, [2]string{short, long})
+            }
+        }
+    }
+    return pairs
+}
+
+// isAbbreviation reports whether short, of at least two letters, is made of
+// letters of long in order, starting with the same letter. eg: js javascript.
+func isAbbreviation(short string, long string) bool {
+    short = strings.ToLower(short)
+    long = strings.ToLower(long)
+    if len(short) < 2 || len(long) <= len(short)+1 || short[0] != long[0] {
+        return false
+    }
+
+    i := 0
+    for j := 0; j < len(long) && i < len(short); j++ {
+        if long[j] == short[i] {
+            i++
+        }
+    }
+    return i == len(short) && len(short)*3 <= len(long)
+}
+
+// editDistance is the levenshtein distance with adjacent transpositions
+// counted as one edit.
+func editDistance(a string, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    d := make([][]int, len(ra)+1)
+    for i := range d {
+        d[i] = make([]int, len(rb)+1)
+        d[i][0] = i
+    }
+    for j := range d[0] {
+        d[0][j] = j
+    }
+
+    for i := 1; i <= len(ra); i++ {
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+            if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+                d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+            }
+        }
+    }
+    return d[len(ra)][len(rb)]
+}
+
+// searchSynonyms searches the store like Search, but a required tag also
+// matches its synonyms.
+func (op *Operator) searchSynonyms(category string, tagStr string) []CodeSegment {
+    if op.aliases == nil || tagStr == "" {
+        return op.store.Search(category, tagStr)
+    }
+
+    matched := op.store.Search(category, "")
+    for _, tag := range strings.Split(tagStr, ",") {
+        ids := map[string]bool{}
+        for _, synonym := range op.aliases.Synonyms(strings.TrimSpace(tag)) {
+            for _, cs := range op.store.Search(category, synonym) {
+                ids[cs.Id] = true
+            }
+        }
+        kept := []CodeSegment{}
+        for _, cs := range matched {
+            if ids[cs.Id] {
+                kept = append(kept, cs)
+            }
+        }
+        matched = kept
+    }
+    return matched
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "math"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// SuggestedTagsNum is how many tags are suggested at most.
+const SuggestedTagsNum = 5
+
+// qualifiedPattern matches package qualified names like json.Marshal, the
+// package is a good tag.
+var qualifiedPattern = regexp.MustCompile(`\b([a-z][a-z0-9]*)\.[A-Za-z_]`)
+
+// stopWords are never suggested as new tags.
+var stopWords = []string{"the", "and", "for", "this", "that", "with", "from", "into", "not", "are", "was",
+    "err", "res", "val", "tmp", "str", "args", "arg", "main", "echo", "print", "println", "self", "none"}
+
+// splitIdentifier splits camelCase and snake_case identifiers into lower
+// case words, eg: readJSONFile -> read, json, file.
+func splitIdentifier(ident string) []string {
+    words := []string{}
+    for _, part := range strings.FieldsFunc(ident, func(r rune) bool { return r == '_' || r == '-' }) {
+        start := 0
+        runes := []rune(part)
+        for i := 1; i < len(runes); i++ {
+            lowerToUpper := runes[i] >= 'A' && runes[i] <= 'Z' && !(runes[i-1] >= 'A' && runes[i-1] <= 'Z')
+            acronymEnd := i+1 < len(runes) && runes[i] >= 'A' && runes[i] <= 'Z' && runes[i-1] >= 'A' && runes[i-1] <= 'Z' &&
+                runes[i+1] >= 'a' && runes[i+1] <= 'z'
+            if lowerToUpper || acronymEnd {
+                words = append(words, strings.ToLower(string(runes[start:i])))
+                start = i
+            }
+        }
+        words = append(words, strings.ToLower(string(runes[start:])))
+    }
+    return words
+}
+
+// SuggestTags proposes tags for cs from the identifiers and keywords of its
+// code and description, its language, and the tags already used with its
+// category.
+func SuggestTags(cs CodeSegment, stats RcsStats) []string {
+    text := cs.Desc + "\n" + cs.Code
+    // the first file is cs.Code.
+    for _, file := range cs.AllFiles()[minInt(1, len(cs.Files)):] {
+        text += "\n" + file.Content
+    }
+
+    counts := map[string]int{}
+    for _, ident := range wordPattern.FindAllString(text, -1) {
+        counts[strings.ToLower(ident)]++
+        for _, word := range splitIdentifier(ident) {
+            counts[word]++
+        }
+    }
+
+    lang := cs.EffectiveLanguage()
+    excluded := append([]string{}, stopWords...)
+    excluded = append(excluded, syntaxes[lang].keywords...)
+    levels := strings.Split(strings.ToLower(cs.Category), string(CategorySep))
+    excluded = append(excluded, levels...)
+
+    scores := map[string]float64{}
+    // tags in use that show up in the content.
+    for _, tag := range stats.AllTags {
+        if tag == "" {
+            continue
+        }
+        if n := counts[strings.ToLower(tag)]; n > 0 {
+            scores[strings.ToLower(tag)] += 2 + float64(minInt(n, 3)) + math.Log(1+float64(stats.TagNumMap[tag]))
+        }
+    }
+    // tags used together with the category and its parents.
+    for i := range levels {
+        cate := strings.Join(strings.Split(cs.Category, string(CategorySep))[:i+1], string(CategorySep))
+        for _, tag := range stats.CateTagsMap[cate] {
+            if tag != "" {
+                scores[strings.ToLower(tag)] += 1
+            }
+        }
+    }
+    for _, m := range qualifiedPattern.FindAllStringSubmatch(text, -1) {
+        scores[m[1]] += 1.5
+    }
+    if lang != "" {
+        scores[lang] += 1.5
+    }
+    // new tags from identifiers used over and over.
+    for word, n := range counts {
+        if n >= 2 && len(word) >= 4 {
+            scores[word] += math.Min(float64(n)*0.5, 2)
+        }
+    }
+
+    tags := []string{}
+    for tag := range scores {
+        if len(tag) >= 2 && !ArrContains(excluded, tag) {
+            tags = append(tags, tag)
+        }
+    }
+    sort.Slice(tags, func(i, j int) bool {
+        if scores[tags[i]] != scores[tags[j]] {
+            return scores[tags[i]] > scores[tags[j]]
+        }
+        return tags[i] < tags[j]
+    })
+    return tags[:minInt(SuggestedTagsNum, len(tags))]
+}
+
+// suggestedTagStr returns the suggested tags of cs as a tag string, in
+// their canonical form.
+func (op *Operator) suggestedTagStr(cs CodeSegment) string {
+    tagStr := strings.Join(SuggestTags(cs, op.store.GetStats()), ",")
+    if op.aliases != nil {
+        tagStr = op.aliases.CanonicalTagStr(tagStr)
+    }
+    return tagStr
+}
+
+// SuggestTags prints the suggested tags of cs, without adding it.
+func (op *Operator) SuggestTags(cs CodeSegment) {
+    if op.err != nil {
+        return
+    }
+    if cs.Language == "" {
+        cs.Language = DetectLanguage(cs.Code)
+    }
+    fmt.Println(op.suggestedTagStr(cs))
+}
+
+// offerTags proposes tags for a segment added without any. On a terminal
+// they can be accepted with enter or replaced, otherwise they are only
+// printed as a hint.
+func (op *Operator) offerTags(cs CodeSegment) string {
+    suggested := op.suggestedTagStr(cs)
+    if suggested == "" {
+        return ""
+    }
+    if !isTerminal(os.Stdin) {
+        fmt.Println("suggested tags:", suggested, "(add them with -t)")
+        return ""
+    }
+
+    fmt.Printf("tags [%s]: ", suggested)
+    answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+    if answer = strings.TrimSpace(answer); answer != "" {
+        // "-" adds the segment without tags.
+        if answer == "-" {
+            return ""
+        }
+        return answer
+    }
+    return suggested
+}
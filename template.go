@@ -0,0 +1,173 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// placeholderPattern matches ${{Name}} and ${{Name:default}}. The double
+// braces keep shell parameter expansions like ${f} or ${USER:-x} out of it.
+// $${{Name}} is an escaped placeholder and renders as a literal ${{Name}}.
+var placeholderPattern = regexp.MustCompile(`\$?\$\{\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}\}`)
+
+type Placeholder struct {
+    Name       string
+    Default    string
+    HasDefault bool
+}
+
+// Placeholders returns the placeholders of code in order of first
+// appearance. The first default given for a name is used.
+func Placeholders(code string) []Placeholder {
+    placeholders := []Placeholder{}
+    index := map[string]int{}
+    for _, m := range placeholderPattern.FindAllStringSubmatchIndex(code, -1) {
+        if strings.HasPrefix(code[m[0]:], "$$") {
+            continue
+        }
+        name := code[m[2]:m[3]]
+        hasDefault := m[4] >= 0
+        def := ""
+        if hasDefault {
+            def = code[m[4]:m[5]]
+        }
+
+        if i, ok := index[name]; ok {
+            if !placeholders[i].HasDefault && hasDefault {
+                placeholders[i].Default = def
+                placeholders[i].HasDefault = true
+            }
+            continue
+        }
+        index[name] = len(placeholders)
+        placeholders = append(placeholders, Placeholder{name, def, hasDefault})
+    }
+    return placeholders
+}
+
+// RenderTemplate substitutes the placeholders of code with values, or their
+// defaults. It fails if a placeholder has neither, or if values has a name
+// code does not use.
+func RenderTemplate(code string, values map[string]string) (string, error) {
+    placeholders := Placeholders(code)
+    known := map[string]Placeholder{}
+    missing := []string{}
+    for _, p := range placeholders {
+        known[p.Name] = p
+        if _, ok := values[p.Name]; !ok && !p.HasDefault {
+            missing = append(missing, p.Name)
+        }
+    }
+
+    unknown := []string{}
+    for name := range values {
+        if _, ok := known[name]; !ok {
+            unknown = append(unknown, name)
+        }
+    }
+    sort.Strings(unknown)
+
+    if len(unknown) > 0 {
+        return "", errors.New("unknown variables: " + strings.Join(unknown, ", "))
+    }
+    if len(missing) > 0 {
+        return "", errors.New("missing variables: " + strings.Join(missing, ", "))
+    }
+
+    return placeholderPattern.ReplaceAllStringFunc(code, func(s string) string {
+        if strings.HasPrefix(s, "$$") {
+            return s[1:]
+        }
+        name := placeholderPattern.FindStringSubmatch(s)[1]
+        if v, ok := values[name]; ok {
+            return v
+        }
+        return known[name].Default
+    }), nil
+}
+
+// parseVars parses args of the form Name=Value.
+func parseVars(args []string) (map[string]string, error) {
+    values := map[string]string{}
+    for _, arg := range args {
+        i := strings.Index(arg, "=")
+        if i <= 0 {
+            return nil, errors.New("invalid variable '" + arg + "', should be Name=Value.")
+        }
+        values[arg[:i]] = arg[i+1:]
+    }
+    return values, nil
+}
+
+// Render prints the code of segment ref with includes expanded, unless
+// noExpand is set, and placeholders filled. When stdin is a terminal,
+// values without a default are prompted for, and with interactive set every
+// value not given is prompted for.
+func (op *Operator) Render(ref string, values map[string]string, interactive bool, noExpand bool) {
+    if op.err != nil {
+        return
+    }
+
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+    if !noExpand {
+        if cs.Code, op.err = op.ExpandIncludes(cs); op.err != nil {
+            return
+        }
+    }
+
+    if isTerminal(os.Stdin) {
+        reader := bufio.NewReader(os.Stdin)
+        for _, p := range Placeholders(cs.Code) {
+            if _, ok := values[p.Name]; ok || (p.HasDefault && !interactive) {
+                continue
+            }
+            // prompts go to stderr, so the rendered code can be piped.
+            if p.HasDefault {
+                fmt.Fprintf(os.Stderr, "%s [%s]: ", p.Name, p.Default)
+            } else {
+                fmt.Fprintf(os.Stderr, "%s: ", p.Name)
+            }
+            line, err := reader.ReadString('\n')
+            if err != nil {
+                op.err = err
+                return
+            }
+            if line = strings.TrimRight(line, "\r\n"); line != "" || !p.HasDefault {
+                values[p.Name] = line
+            }
+        }
+    }
+
+    code, err := RenderTemplate(cs.Code, values)
+    if err != nil {
+        op.err = err
+        return
+    }
+    fmt.Println(code)
+    op.recordUse(cs.Id)
+}
+
+// ListPlaceholders prints the placeholders of segment ref.
+func (op *Operator) ListPlaceholders(ref string) {
+    cs, err := op.getByRef(ref)
+    if err != nil {
+        op.err = err
+        return
+    }
+    for _, p := range Placeholders(cs.Code) {
+        if p.HasDefault {
+            fmt.Printf("%-24s%s\n", p.Name, p.Default)
+        } else {
+            fmt.Printf("%-24s(required)\n", p.Name)
+        }
+    }
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import "os"
+
+// isTerminal reports whether f is a character device, eg: a terminal.
+func isTerminal(f *os.File) bool {
+    fi, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ttySize returns zeros as the terminal size is unknown on this platform.
+func ttySize(f *os.File) (rows int, cols int) {
+    return 0, 0
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+    "os"
+    "syscall"
+    "unsafe"
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+    _, _, errno := getWinsize(f)
+    return errno == 0
+}
+
+// ttySize returns the number of rows and columns of terminal f, or zeros if
+// unknown.
+func ttySize(f *os.File) (rows int, cols int) {
+    rows, cols, errno := getWinsize(f)
+    if errno != 0 {
+        return 0, 0
+    }
+    return rows, cols
+}
+
+func getWinsize(f *os.File) (rows int, cols int, errno syscall.Errno) {
+    var ws struct {
+        Row, Col, Xpixel, Ypixel uint16
+    }
+    _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+    return int(ws.Row), int(ws.Col), errno
+}
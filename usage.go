@@ -0,0 +1,231 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const usageFileName = "usage.rcs"
+
+// UsageRecord is how often a segment was used and when.
+type UsageRecord struct {
+    Count    int
+    LastUsed time.Time
+    // Added is when the segment was added, zero for segments added before
+    // usage was tracked.
+    Added time.Time
+}
+
+// Usage tracks how often segments are catted, rendered, inserted, run or
+// picked, stored in the codebase as lines of "id|count|last-used|added"
+// with unix times.
+type Usage struct {
+    FilePath string
+    records  map[string]UsageRecord
+}
+
+func LoadUsage(fpath string) (*Usage, error) {
+    u := &Usage{fpath, map[string]UsageRecord{}}
+    f, err := os.Open(fpath)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return u, nil
+        }
+        return u, err
+    }
+    defer f.Close()
+
+    var unixTime = func(s string) time.Time {
+        sec, _ := strconv.ParseInt(s, 10, 64)
+        if sec == 0 {
+            return time.Time{}
+        }
+        return time.Unix(sec, 0)
+    }
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        flds := strings.Split(strings.TrimSpace(scanner.Text()), "|")
+        if len(flds) != 4 {
+            continue
+        }
+        count, _ := strconv.Atoi(flds[1])
+        u.records[flds[0]] = UsageRecord{count, unixTime(flds[2]), unixTime(flds[3])}
+    }
+    return u, scanner.Err()
+}
+
+func (u *Usage) save() error {
+    ids := []string{}
+    for id := range u.records {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+
+    var unix = func(t time.Time) string {
+        if t.IsZero() {
+            return "0"
+        }
+        return strconv.FormatInt(t.Unix(), 10)
+    }
+
+    f, err := os.OpenFile(u.FilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    for _, id := range ids {
+        r := u.records[id]
+        if _, err = f.WriteString(id + "|" + strconv.Itoa(r.Count) + "|" + unix(r.LastUsed) + "|" + unix(r.Added) + "\n"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Record counts one use of each of ids and saves the usage.
+func (u *Usage) Record(ids ...string) error {
+    now := time.Now()
+    for _, id := range ids {
+        r := u.records[id]
+        r.Count++
+        r.LastUsed = now
+        u.records[id] = r
+    }
+    return u.save()
+}
+
+// RecordAdd records that id was added now.
+func (u *Usage) RecordAdd(id string) error {
+    u.records[id] = UsageRecord{Added: time.Now()}
+    return u.save()
+}
+
+// Forget drops the usage of removed segment id.
+func (u *Usage) Forget(id string) error {
+    if _, ok := u.records[id]; !ok {
+        return nil
+    }
+    delete(u.records, id)
+    return u.save()
+}
+
+// Of returns the usage of segment id.
+func (u *Usage) Of(id string) UsageRecord {
+    return u.records[id]
+}
+
+// recordUse counts a use of the segments. Failing to save the usage does
+// not fail the command that used them.
+func (op *Operator) recordUse(ids ...string) {
+    if op.usage != nil && len(ids) > 0 {
+        op.usage.Record(ids...)
+    }
+}
+
+// rankByUsage orders segs by use count, most used first, keeping the order
+// of equally used ones.
+func (op *Operator) rankByUsage(segs []CodeSegment) {
+    if op.usage == nil {
+        return
+    }
+    sort.SliceStable(segs, func(i, j int) bool {
+        return op.usage.Of(segs[i].Id).Count > op.usage.Of(segs[j].Id).Count
+    })
+}
+
+// parseAge parses durations like 180d, 12h or 30m.
+func parseAge(s string) (time.Duration, error) {
+    if strings.HasSuffix(s, "d") {
+        days, err := strconv.Atoi(s[:len(s)-1])
+        if err != nil || days < 0 {
+            return 0, errors.New("invalid duration: " + s)
+        }
+        return time.Duration(days) * 24 * time.Hour, nil
+    }
+    d, err := time.ParseDuration(s)
+    if err != nil {
+        return 0, errors.New("invalid duration: " + s)
+    }
+    return d, nil
+}
+
+func formatTime(t time.Time) string {
+    if t.IsZero() {
+        return "never"
+    }
+    return t.Format("2006-01-02 15:04")
+}
+
+func (op *Operator) printUsageTable(segs []CodeSegment) {
+    rows := [][]string{}
+    for _, cs := range segs {
+        r := op.usage.Of(cs.Id)
+        rows = append(rows, []string{strconv.Itoa(r.Count), formatTime(r.LastUsed), cs.Id, cs.Category, cs.Tags})
+    }
+    printTable([]string{"COUNT", "LAST-USED", "ID", "CATEGORY", "TAGS"}, rows)
+}
+
+// Top prints the n most used segments.
+func (op *Operator) Top(n int) {
+    if op.err != nil {
+        return
+    }
+    segs := []CodeSegment{}
+    for _, cs := range op.store.Search("", "") {
+        if op.usage.Of(cs.Id).Count > 0 {
+            segs = append(segs, cs)
+        }
+    }
+    op.rankByUsage(segs)
+    op.printUsageTable(segs[:minInt(n, len(segs))])
+}
+
+// Recent prints the n most recently used segments.
+func (op *Operator) Recent(n int) {
+    if op.err != nil {
+        return
+    }
+    segs := []CodeSegment{}
+    for _, cs := range op.store.Search("", "") {
+        if !op.usage.Of(cs.Id).LastUsed.IsZero() {
+            segs = append(segs, cs)
+        }
+    }
+    sort.SliceStable(segs, func(i, j int) bool {
+        return op.usage.Of(segs[i].Id).LastUsed.After(op.usage.Of(segs[j].Id).LastUsed)
+    })
+    op.printUsageTable(segs[:minInt(n, len(segs))])
+}
+
+// Stale prints the segments not used for unusedFor, the ones added before
+// usage was tracked and never used since included, as cleanup candidates.
+func (op *Operator) Stale(unusedFor time.Duration) {
+    if op.err != nil {
+        return
+    }
+    since := time.Now().Add(-unusedFor)
+    segs := []CodeSegment{}
+    for _, cs := range op.store.Search("", "") {
+        r := op.usage.Of(cs.Id)
+        last := r.LastUsed
+        if last.IsZero() {
+            last = r.Added
+        }
+        if last.Before(since) {
+            segs = append(segs, cs)
+        }
+    }
+    if len(segs) == 0 {
+        fmt.Println("no stale code segment.")
+        return
+    }
+    op.printUsageTable(segs)
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+    "fmt"
+    "strings"
+    "unicode/utf8"
+)
+
 func ArrContains(strArr []string, s string) bool {
     for _, str := range strArr {
         if s == str {
@@ -9,3 +15,47 @@ func ArrContains(strArr []string, s string) bool {
 
     return false
 }
+
+func minInt(a int, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
+
+func maxInt(a int, b int) int {
+    if a > b {
+        return a
+    }
+    return b
+}
+
+// printTable prints rows under head, each column as wide as its longest
+// value so that long ids stay aligned.
+func printTable(head []string, rows [][]string) {
+    widths := make([]int, len(head))
+    for i, h := range head {
+        widths[i] = utf8.RuneCountInString(h)
+    }
+    for _, row := range rows {
+        for i, v := range row {
+            widths[i] = maxInt(widths[i], utf8.RuneCountInString(v))
+        }
+    }
+
+    var printRow = func(row []string) {
+        line := ""
+        for i, v := range row {
+            if i == len(row)-1 {
+                line += v
+            } else {
+                line += v + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(v)+2)
+            }
+        }
+        fmt.Println(line)
+    }
+    printRow(head)
+    for _, row := range rows {
+        printRow(row)
+    }
+}
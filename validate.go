@@ -0,0 +1,168 @@
+package main
+
+import (
+    "errors"
+    "os"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// MaxTags is the max number of tags a code segment can have.
+const MaxTags = 8
+
+// tagCase controls case normalization of tags and categories. It is read
+// from $RCS_TAG_CASE and can be "lower", "upper", or empty to keep the case
+// as typed.
+var tagCase = os.Getenv("RCS_TAG_CASE")
+
+// categoryPunct are the non letter/digit charactors allowed in a category.
+const categoryPunct = "-_.+#"
+
+// normalizeSegment validates cs and returns it with category and tags
+// normalized. It is applied on every path that writes a code segment.
+func normalizeSegment(cs CodeSegment) (CodeSegment, error) {
+    return normalizeSegmentTags(cs, MaxTags)
+}
+
+// normalizeChange is normalizeSegment for a change of stored segment
+// origin. Segments stored before the tag limit have more tags, a change
+// is accepted as long as it does not add tags to them.
+func normalizeChange(origin CodeSegment, cs CodeSegment) (CodeSegment, error) {
+    return normalizeSegmentTags(cs, maxInt(MaxTags, len(splitTags(origin.Tags))))
+}
+
+func normalizeSegmentTags(cs CodeSegment, maxTags int) (CodeSegment, error) {
+    isEmpty := strings.TrimSpace(cs.Code) == ""
+    names := []string{}
+    for _, file := range cs.AllFiles() {
+        if err := validateFileName(file.Name); err != nil {
+            return cs, err
+        }
+        if ArrContains(names, file.Name) {
+            return cs, errors.New("duplicated file name: " + file.Name)
+        }
+        names = append(names, file.Name)
+        isEmpty = isEmpty && strings.TrimSpace(file.Content) == ""
+    }
+    if isEmpty {
+        return cs, errors.New("content can not be empty.")
+    }
+
+    if cs.Category == "" && cs.Tags == "" {
+        return cs, errors.New("category and tags can not be both empty.")
+    }
+
+    cate, err := normalizeCategory(cs.Category)
+    if err != nil {
+        return cs, err
+    }
+
+    tagStr, err := normalizeTagsMax(cs.Tags, maxTags)
+    if err != nil {
+        return cs, err
+    }
+
+    if cate == "" && tagStr == "" {
+        return cs, errors.New("category and tags can not be both empty.")
+    }
+
+    lang, err := NormalizeLanguage(cs.Language)
+    if err != nil {
+        return cs, err
+    }
+
+    cs.Category = cate
+    cs.Tags = tagStr
+    cs.Language = lang
+    return cs, nil
+}
+
+// normalizeCategory trims and case-normalizes cate. A category is one word
+// of letters, digits and the charactors in categoryPunct, or a hierarchy of
+// such words joined by CategorySep, eg: go/net/http.
+func normalizeCategory(cate string) (string, error) {
+    cate = strings.TrimSpace(cate)
+    if cate == "" {
+        return cate, nil
+    }
+    for _, level := range strings.Split(cate, string(CategorySep)) {
+        if level == "" {
+            return "", errors.New("invalid category '" + cate + "': empty level in hierarchy.")
+        }
+        for _, r := range level {
+            if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(categoryPunct, r) {
+                return "", errors.New("invalid category '" + cate + "': charactor " + strconv.QuoteRune(r) +
+                    " is not allowed, category should be one word of letters, digits and '" + categoryPunct +
+                    "', or such words joined by '" + string(CategorySep) + "'.")
+            }
+        }
+    }
+    return normalizeCase(cate)
+}
+
+// normalizeTags splits a comma separated tag string, trims, case-normalizes
+// and de-duplicates the tags, and checks the tag count limit.
+func normalizeTags(tagStr string) (string, error) {
+    return normalizeTagsMax(tagStr, MaxTags)
+}
+
+func normalizeTagsMax(tagStr string, maxTags int) (string, error) {
+    tags := []string{}
+    for _, t := range strings.Split(tagStr, ",") {
+        t = strings.TrimSpace(t)
+        if t == "" {
+            continue
+        }
+
+        for _, r := range t {
+            if r == '|' || unicode.IsSpace(r) {
+                return "", errors.New("invalid tag '" + t + "': charactor " + strconv.QuoteRune(r) + " is not allowed.")
+            }
+        }
+
+        t, err := normalizeCase(t)
+        if err != nil {
+            return "", err
+        }
+
+        isDup := false
+        for _, tag := range tags {
+            if strings.EqualFold(tag, t) {
+                isDup = true
+                break
+            }
+        }
+        if !isDup {
+            tags = append(tags, t)
+        }
+    }
+
+    if len(tags) > maxTags {
+        return "", errors.New("too many tags: " + strconv.Itoa(len(tags)) + ", at most " + strconv.Itoa(MaxTags) + " tags are allowed.")
+    }
+    return strings.Join(tags, ","), nil
+}
+
+// splitTags returns the non empty tags of a comma separated tag string.
+func splitTags(tagStr string) []string {
+    tags := []string{}
+    for _, t := range strings.Split(tagStr, ",") {
+        if t = strings.TrimSpace(t); t != "" {
+            tags = append(tags, t)
+        }
+    }
+    return tags
+}
+
+func normalizeCase(s string) (string, error) {
+    switch tagCase {
+    case "":
+        return s, nil
+    case "lower":
+        return strings.ToLower(s), nil
+    case "upper":
+        return strings.ToUpper(s), nil
+    }
+    return "", errors.New("invalid $RCS_TAG_CASE '" + tagCase + "', should be lower, upper or empty.")
+}